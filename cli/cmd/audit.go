@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:               "audit",
+	Short:             "Display the audit log",
+	Long:              `Display the audit log of management actions and webhook triggers. Optionally provide a service name as the first argument.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: AutocompleteServiceName,
+	Run: func(cmd *cobra.Command, args []string) {
+		params := url.Values{}
+		if len(args) > 0 {
+			params.Set("service", args[0])
+		}
+		for _, flag := range []string{"action", "actor", "result", "since"} {
+			value, _ := cmd.Flags().GetString(flag)
+			if value != "" {
+				params.Set(flag, value)
+			}
+		}
+		lines, _ := cmd.Flags().GetInt("lines")
+		params.Set("limit", strconv.Itoa(lines))
+
+		entries, err := Client.Audit(params)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+
+		var table Table
+		table = append(table, []string{"Time", "Actor", "IP", "Action", "Service", "Result", "Commit", "Detail"})
+		for _, entry := range entries {
+			commit := entry.Commit
+			if len(commit) > 12 {
+				commit = commit[:12]
+			}
+			result := string(entry.Result)
+			if entry.Error != "" {
+				result += ": " + entry.Error
+			}
+
+			table = append(
+				table,
+				[]string{
+					entry.Time.Local().Format("2006-01-02 15:04:05"),
+					entry.Actor,
+					entry.IP,
+					entry.Action,
+					entry.Service,
+					result,
+					commit,
+					entry.Detail,
+				},
+			)
+		}
+		table.Print()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.Flags().String("action", "", "only show an action, eg. update or rollback")
+	auditCmd.Flags().String("actor", "", "only show actions by an actor, eg. secret, webhook or token:<name>")
+	auditCmd.Flags().String("result", "", "only show a result: success, failure or denied")
+	auditCmd.Flags().String("since", "", "only show entries since a time (RFC 3339) or duration ago (eg. 24h)")
+	auditCmd.Flags().IntP("lines", "n", 50, "number of entries to show")
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// backupsCmd represents the backups command
+var backupsCmd = &cobra.Command{
+	Use:   "backups",
+	Short: "Manage server config backups",
+	Long:  `List and restore backups of the server config. A backup is made every time the config is saved.`,
+}
+
+var backupsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List config backups",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		backups, err := Client.ConfigBackups()
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+
+		var table Table
+		table = append(table, []string{"Name", "Time", "Size"})
+		for _, backup := range backups {
+			table = append(
+				table,
+				[]string{
+					backup.Name,
+					backup.Time.Format("2006-01-02 15:04:05"),
+					fmt.Sprintf("%d B", backup.Size),
+				},
+			)
+		}
+		table.Print()
+	},
+}
+
+var backupsRestoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore a config backup",
+	Long:  `Restore a config backup, provide the name as the first argument. The current config is backed up first and the restored config is applied immediately.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := Client.RestoreConfigBackup(args[0])
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+
+		fmt.Println("Config restored")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(backupsCmd)
+	backupsCmd.AddCommand(backupsListCmd)
+	backupsCmd.AddCommand(backupsRestoreCmd)
+}
@@ -13,7 +13,7 @@ import (
 var configureCmd = &cobra.Command{
 	Use:   "configure",
 	Short: "Configure the hotify CLI",
-	Long:  `Enter your server address and API secret to use the hotify CLI.`,
+	Long:  `Enter your server address and API secret or token to use the hotify CLI.`,
 	Args:  cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
 		var configPath string
@@ -37,7 +37,13 @@ var configureCmd = &cobra.Command{
 
 		var config config.Config
 		Prompt("Server address", &config.Address)
-		Prompt("API secret", &config.Secret)
+		var useToken bool
+		PromptBool("Use a scoped API token instead of the API secret?", &useToken)
+		if useToken {
+			Prompt("API token", &config.Token)
+		} else {
+			Prompt("API secret", &config.Secret)
+		}
 		config.Save(configPath)
 
 		fmt.Println("\nConfiguration saved")
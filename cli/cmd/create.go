@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"hotify/pkg/config"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -17,6 +18,15 @@ var createCmd = &cobra.Command{
 		var config config.ServiceConfig
 		Prompt("Service name", &config.Name)
 		Prompt("Repository", &config.Repo)
+
+		var pin bool
+		PromptBool("Pin branch, tag or commit", &pin)
+		if pin {
+			Prompt("Branch (empty for default)", &config.Branch)
+			Prompt("Tag (empty for none)", &config.Tag)
+			Prompt("Commit (empty for none)", &config.Commit)
+		}
+
 		Prompt("Exec command", &config.Exec)
 		Prompt("Build command", &config.Build)
 		Prompt("Webhook secret", &config.Secret)
@@ -26,11 +36,110 @@ var createCmd = &cobra.Command{
 			PromptInt("Max restarts", &config.MaxRestarts)
 		}
 
+		var replicated bool
+		PromptBool("Run several replicas", &replicated)
+		if replicated {
+			PromptInt("Replicas", &config.Replicas)
+		}
+
 		var proxy bool
 		PromptBool("Use proxy", &proxy)
 		if proxy {
-			Prompt("Match", &config.Proxy.Match)
-			Prompt("Upstream", &config.Proxy.Upstream)
+			var hosts, paths string
+			Prompt("Hosts (comma separated, eg. example.com,www.example.com)", &hosts)
+			config.Proxy.Hosts = splitList(hosts)
+			Prompt("Path prefixes (comma separated, empty for all paths)", &paths)
+			config.Proxy.Paths = splitList(paths)
+			if len(config.Proxy.Paths) > 0 {
+				PromptBool("Strip path prefix before forwarding", &config.Proxy.StripPrefix)
+			}
+			if config.Replicas > 1 {
+				Prompt("Upstream of the first replica (the others use the following ports)", &config.Proxy.Upstream)
+			} else {
+				Prompt("Upstream", &config.Proxy.Upstream)
+			}
+
+			var rolling bool
+			if config.Replicas > 1 {
+				Prompt("Load balancing (round_robin, least_conn, random, first or ip_hash, empty for round_robin)", &config.Proxy.LoadBalancing)
+				PromptBool("Use rolling deployments", &rolling)
+				if rolling {
+					config.Deploy = "rolling"
+				}
+			}
+
+			var blueGreen bool
+			if !rolling {
+				PromptBool("Use blue/green deployments (requires a health check)", &blueGreen)
+			}
+			if blueGreen {
+				config.Deploy = "bluegreen"
+				Prompt("Alternate upstream", &config.Proxy.AlternateUpstream)
+			}
+		}
+
+		var healthCheck bool
+		PromptBool("Use health check", &healthCheck)
+		if healthCheck {
+			for config.HealthCheck.Type != "http" && config.HealthCheck.Type != "tcp" && config.HealthCheck.Type != "exec" {
+				Prompt("Health check type (http, tcp or exec)", &config.HealthCheck.Type)
+			}
+			switch config.HealthCheck.Type {
+			case "http":
+				Prompt("Health check URL", &config.HealthCheck.URL)
+			case "tcp":
+				Prompt("Health check address", &config.HealthCheck.Address)
+			case "exec":
+				Prompt("Health check command", &config.HealthCheck.Command)
+			}
+			// unhealthy processes are restarted by the restart on failure logic
+			if config.Restart {
+				PromptBool("Restart when unhealthy", &config.HealthCheck.Restart)
+			}
+		}
+
+		var env bool
+		PromptBool("Set environment variables", &env)
+		if env {
+			config.Env = make(map[string]string)
+			for {
+				var variable string
+				Prompt("Variable (KEY=VALUE, empty to finish)", &variable)
+				if variable == "" {
+					break
+				}
+
+				key, value, found := strings.Cut(variable, "=")
+				if !found {
+					fmt.Println("Invalid input")
+					continue
+				}
+				config.Env[key] = value
+
+				var secret bool
+				PromptBool("Hide value in API responses", &secret)
+				if secret {
+					config.SecretEnv = append(config.SecretEnv, key)
+				}
+			}
+
+			for {
+				var file string
+				Prompt("Env file (relative to the repository, empty to finish)", &file)
+				if file == "" {
+					break
+				}
+				config.EnvFile = append(config.EnvFile, file)
+			}
+		}
+
+		for {
+			var dependency string
+			Prompt("Depends on service (empty to finish)", &dependency)
+			if dependency == "" {
+				break
+			}
+			config.DependsOn = append(config.DependsOn, dependency)
 		}
 
 		err := Client.CreateService(&config)
@@ -42,6 +151,18 @@ var createCmd = &cobra.Command{
 	},
 }
 
+// splitList splits a comma separated list, ignoring empty entries
+func splitList(list string) []string {
+	var entries []string
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
 func init() {
 	rootCmd.AddCommand(createCmd)
 }
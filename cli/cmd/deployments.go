@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"fmt"
+	"hotify/pkg/logs"
+	"net/url"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+// Number of build log lines printed by --log
+const maxBuildLogLines = 10000
+
+// deploymentsCmd represents the deployments command
+var deploymentsCmd = &cobra.Command{
+	Use:               "deployments",
+	Short:             "Display the deployment history of a service",
+	Long:              `Display the deployment history of a service, provide the name as the first argument. Use --log to print the build log of a deployment.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: AutocompleteServiceName,
+	Run: func(cmd *cobra.Command, args []string) {
+		log, _ := cmd.Flags().GetInt("log")
+		if log != 0 {
+			// build output is kept in the service logs, tagged with the deployment
+			params := url.Values{}
+			params.Set("phase", string(logs.PhaseBuild))
+			params.Set("deployment", strconv.Itoa(log))
+			params.Set("limit", strconv.Itoa(maxBuildLogLines))
+			page, err := Client.Logs(args[0], params)
+			if err != nil {
+				fmt.Printf("Error: %s\n", err)
+				return
+			}
+			if len(page.Entries) == 0 {
+				fmt.Println("No build log found, it may have been rotated out of the logs")
+				return
+			}
+			for _, entry := range page.Entries {
+				PrintLogEntry(entry)
+			}
+			if page.More {
+				PrintlnBold(fmt.Sprintf("\nOnly the last %d lines are shown", maxBuildLogLines))
+			}
+			return
+		}
+
+		history, err := Client.Deployments(args[0])
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+
+		var table Table
+		table = append(table, []string{"Deployment", "Commit", "Time", "Trigger", "State", "Artifacts"})
+		for _, deployment := range history.Deployments {
+			id := fmt.Sprintf("%d", deployment.ID)
+			if deployment.ID == history.Current {
+				id += " (current)"
+			}
+			commit := deployment.Commit
+			if len(commit) > 12 {
+				commit = commit[:12]
+			}
+
+			table = append(
+				table,
+				[]string{
+					id,
+					commit,
+					deployment.Time.Format("2006-01-02 15:04"),
+					string(deployment.Trigger),
+					string(deployment.State),
+					fmt.Sprintf("%t", deployment.Artifacts),
+				},
+			)
+		}
+		table.Print()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(deploymentsCmd)
+	deploymentsCmd.Flags().Int("log", 0, "print the build log of a deployment")
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hotify/pkg/config"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/spf13/cobra"
+)
+
+// editCmd represents the edit command
+var editCmd = &cobra.Command{
+	Use:               "edit",
+	Short:             "Edit the configuration of a service",
+	Long:              `Open the configuration of a service in $EDITOR, provide the name as the first argument. Changes are applied when the editor exits. The webhook secret and secret environment values are shown as ` + config.RedactedValue + ` and stay unchanged.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: AutocompleteServiceName,
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		service, err := Client.Service(name)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+
+		original, err := toml.Marshal(service.Config)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+
+		file, err := os.CreateTemp("", fmt.Sprintf("hotify-%s-*.toml", name))
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		defer os.Remove(file.Name())
+		_, err = file.Write(original)
+		file.Close()
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+
+		for {
+			err = OpenEditor(file.Name())
+			if err != nil {
+				fmt.Printf("Error: %s\n", err)
+				return
+			}
+
+			edited, err := os.ReadFile(file.Name())
+			if err != nil {
+				fmt.Printf("Error: %s\n", err)
+				return
+			}
+			if bytes.Equal(edited, original) {
+				fmt.Println("No changes")
+				return
+			}
+
+			var serviceConfig config.ServiceConfig
+			decoder := toml.NewDecoder(bytes.NewReader(edited))
+			decoder.DisallowUnknownFields()
+			err = decoder.Decode(&serviceConfig)
+			if err == nil {
+				err = editService(name, service.Config, &serviceConfig)
+			}
+			if err == nil {
+				fmt.Println("Service updated")
+				return
+			}
+
+			fmt.Printf("Error: %s\n", err)
+			var again bool
+			PromptBool("Edit again?", &again)
+			if !again {
+				return
+			}
+		}
+	},
+}
+
+// editService sends the edited config as a merge patch, removed env variables are set to null
+func editService(name string, original *config.ServiceConfig, edited *config.ServiceConfig) error {
+	marshaled, err := json.Marshal(edited)
+	if err != nil {
+		return err
+	}
+	var patch map[string]any
+	err = json.Unmarshal(marshaled, &patch)
+	if err != nil {
+		return err
+	}
+
+	if env, ok := patch["env"].(map[string]any); ok {
+		for key := range original.Env {
+			if _, ok := env[key]; !ok {
+				env[key] = nil
+			}
+		}
+	}
+
+	_, err = Client.EditService(name, patch)
+	return err
+}
+
+// OpenEditor opens path in $VISUAL or $EDITOR, falling back to vi
+func OpenEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	// the editor may contain arguments, eg. "code --wait"
+	args := append(strings.Fields(editor), path)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+func init() {
+	rootCmd.AddCommand(editCmd)
+}
@@ -6,11 +6,6 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var statusMap = map[int]string{
-	0: "running",
-	1: "stopped",
-}
-
 // listCmd represents the list command
 var listCmd = &cobra.Command{
 	Use:   "list",
@@ -24,18 +19,51 @@ var listCmd = &cobra.Command{
 			return
 		}
 		var table Table
-		table = append(table, []string{"Name", "Status", "Restarts"})
+		table = append(table, []string{"Name", "Status", "Health", "Replicas", "Restarts", "Last Exit", "Reason", "Proxy"})
 		for _, service := range services {
+			proxy := "-"
+			if service.ProxyError != "" {
+				proxy = service.ProxyError
+			} else if service.Config.Proxy.Enabled() {
+				proxy = "ok"
+			}
+
+			running := 0
+			for _, replica := range service.Replicas {
+				if replica.PID != 0 {
+					running++
+				}
+			}
+
+			lastExit := "-"
+			if service.LastExitCode != nil {
+				lastExit = fmt.Sprintf("%d (%s)", *service.LastExitCode, service.LastExitTime.Format("01-02 15:04"))
+			}
+
 			table = append(
 				table,
 				[]string{
 					service.Config.Name,
-					statusMap[int(service.Status)],
+					string(service.Status),
+					string(service.Health),
+					fmt.Sprintf("%d/%d", running, service.Config.ReplicaCount()),
 					fmt.Sprintf("%d", service.Restarts),
+					lastExit,
+					service.Reason,
+					proxy,
 				},
 			)
 		}
 		table.Print()
+
+		progress, err := Client.InitProgress()
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		if progress.Running {
+			fmt.Printf("\nInitializing services: %d of %d done\n", progress.Done(), len(progress.Services))
+		}
 	},
 }
 
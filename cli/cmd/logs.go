@@ -2,7 +2,9 @@ package cmd
 
 import (
 	"fmt"
-	"time"
+	"hotify/pkg/logs"
+	"net/url"
+	"strconv"
 
 	"github.com/spf13/cobra"
 )
@@ -11,43 +13,90 @@ import (
 var logsCmd = &cobra.Command{
 	Use:               "logs",
 	Short:             "Get logs for a service",
-	Long:              `Get logs for a service, provide the name as the first argument.`,
+	Long:              `Get logs for a service, provide the name as the first argument. Use --follow to stream new lines in real-time.`,
 	Args:              cobra.ExactArgs(1),
 	ValidArgsFunction: AutocompleteServiceName,
 	Run: func(cmd *cobra.Command, args []string) {
 		name := args[0]
+		follow, _ := cmd.Flags().GetBool("follow")
 		live, _ := cmd.Flags().GetBool("live")
+		lines, _ := cmd.Flags().GetInt("lines")
+		offset, _ := cmd.Flags().GetInt("offset")
+		since, _ := cmd.Flags().GetString("since")
+		stderr, _ := cmd.Flags().GetBool("stderr")
+		build, _ := cmd.Flags().GetBool("build")
 
-		if live {
-			var numLogs int
+		params := url.Values{}
+		if since != "" {
+			params.Set("since", since)
+		}
+		if stderr {
+			params.Set("stream", string(logs.StreamStderr))
+		}
+		if build {
+			params.Set("phase", string(logs.PhaseBuild))
+		}
+
+		if follow || live {
+			params.Set("tail", strconv.Itoa(lines))
+
+			// the server ends the stream if we fall behind, resume after the last entry
+			var last uint64
 			for {
-				service, err := Client.Service(name)
+				err := Client.StreamLogs(name, params, func(entry logs.Entry) {
+					PrintLogEntry(entry)
+					last = entry.Seq
+				})
 				if err != nil {
 					fmt.Printf("Error: %s\n", err)
 					return
 				}
-				newLogs := service.Logs[numLogs:]
-				for _, log := range newLogs {
-					fmt.Print(log)
-				}
-				numLogs = len(service.Logs)
 
-				time.Sleep(1 * time.Second)
+				params.Set("after", strconv.FormatUint(last, 10))
 			}
 		}
 
-		service, err := Client.Service(name)
+		params.Set("offset", strconv.Itoa(offset))
+		params.Set("limit", strconv.Itoa(lines))
+		page, err := Client.Logs(name, params)
 		if err != nil {
 			fmt.Printf("Error: %s\n", err)
 			return
 		}
-		for _, log := range service.Logs {
-			fmt.Print(log)
+		for _, entry := range page.Entries {
+			PrintLogEntry(entry)
+		}
+		if page.More {
+			PrintlnBold(fmt.Sprintf("\nOlder lines available, use --offset %d", offset+lines))
 		}
 	},
 }
 
+// PrintLogEntry prints an entry with its time and phase, stderr is shown in red
+func PrintLogEntry(entry logs.Entry) {
+	prefix := "-"
+	if !entry.Time.IsZero() {
+		prefix = entry.Time.Local().Format("2006-01-02 15:04:05")
+	}
+	if entry.Phase == logs.PhaseBuild {
+		prefix += " [build]"
+	}
+
+	if entry.Stream == logs.StreamStderr {
+		fmt.Printf("\033[2m%s\033[0m \033[31m%s\033[0m\n", prefix, entry.Message)
+	} else {
+		fmt.Printf("\033[2m%s\033[0m %s\n", prefix, entry.Message)
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(logsCmd)
-	logsCmd.Flags().BoolP("live", "l", false, "update logs in real-time")
+	logsCmd.Flags().BoolP("follow", "f", false, "stream new lines in real-time")
+	logsCmd.Flags().BoolP("live", "l", false, "stream new lines in real-time")
+	logsCmd.Flags().MarkDeprecated("live", "use --follow instead")
+	logsCmd.Flags().IntP("lines", "n", 100, "number of lines to show")
+	logsCmd.Flags().Int("offset", 0, "number of newest lines to skip")
+	logsCmd.Flags().String("since", "", "only show lines since a time (RFC 3339) or duration ago (eg. 10m)")
+	logsCmd.Flags().Bool("stderr", false, "only show lines written to stderr")
+	logsCmd.Flags().Bool("build", false, "only show build output")
 }
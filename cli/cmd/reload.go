@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// reloadCmd represents the reload command
+var reloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Reload the server config",
+	Long:  `Make the server read its config file again. Only services whose configuration changed are restarted.`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := Client.Reload()
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			fmt.Println("Check the server logs for details")
+			return
+		}
+		fmt.Println("Config reloaded")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reloadCmd)
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+// rollbackCmd represents the rollback command
+var rollbackCmd = &cobra.Command{
+	Use:               "rollback",
+	Short:             "Roll back a service to a previous deployment",
+	Long:              `Roll back a service, provide the name as the first argument and the deployment number as the second argument. Use the deployments command to list them.`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: AutocompleteServiceName,
+	Run: func(cmd *cobra.Command, args []string) {
+		deployment, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Println("Invalid deployment number")
+			return
+		}
+
+		err = Client.RollbackService(args[0], deployment)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		fmt.Println("Service rolled back")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+}
@@ -35,11 +35,15 @@ func init() {
 
 	var config config.Config
 	config.Load(path)
-	Client = api.NewClient(config.Address, config.Secret)
+	if config.Token != "" {
+		Client = api.NewTokenClient(config.Address, config.Token)
+	} else {
+		Client = api.NewClient(config.Address, config.Secret)
+	}
 
 	rootCmd.PersistentFlags().StringP("config", "c", path, "Path to the config file")
 
-	if config.Address == "" || config.Secret == "" {
+	if config.Address == "" || (config.Secret == "" && config.Token == "") {
 		PrintlnBold("You must configure the CLI before using it.\n")
 		configureCmd.Run(nil, nil)
 	}
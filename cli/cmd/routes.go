@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// routesCmd represents the routes command
+var routesCmd = &cobra.Command{
+	Use:   "routes",
+	Short: "Display the reverse proxy routes",
+	Long:  `Display the routes hotify configured in the reverse proxy in a table`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		routes, err := Client.ProxyRoutes()
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+
+		var table Table
+		table = append(table, []string{"ID", "Hosts", "Paths", "Upstreams"})
+		for _, route := range routes {
+			hosts := "*"
+			if len(route.Hosts) > 0 {
+				hosts = strings.Join(route.Hosts, ", ")
+			}
+			paths := "/"
+			if len(route.Paths) > 0 {
+				paths = strings.Join(route.Paths, ", ")
+			}
+			if route.StripPrefix {
+				paths += " (stripped)"
+			}
+
+			table = append(
+				table,
+				[]string{
+					route.ID,
+					hosts,
+					paths,
+					strings.Join(route.Upstreams, ", "),
+				},
+			)
+		}
+		table.Print()
+	},
+}
+
+var routesReconcileCmd = &cobra.Command{
+	Use:   "reconcile",
+	Short: "Fix the reverse proxy routes",
+	Long:  `Add missing routes, fix routes with a stale upstream and remove routes of deleted services. Use --dry-run to only show the changes.`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		changes, err := Client.ReconcileProxy(dryRun)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+
+		if len(changes) == 0 {
+			fmt.Println("Routes are up to date")
+			return
+		}
+		for _, change := range changes {
+			fmt.Println(change)
+		}
+		if dryRun {
+			PrintlnBold("Dry run, nothing was changed")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(routesCmd)
+	routesCmd.AddCommand(routesReconcileCmd)
+
+	routesReconcileCmd.Flags().Bool("dry-run", false, "only show the changes")
+}
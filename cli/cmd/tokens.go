@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"fmt"
+	"hotify/pkg/config"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// tokensCmd represents the tokens command
+var tokensCmd = &cobra.Command{
+	Use:   "tokens",
+	Short: "Manage scoped API tokens",
+	Long:  `Create, list and revoke API tokens. Tokens have a scope (read, deploy or admin) and may be restricted to some services.`,
+}
+
+var tokensListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List API tokens",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		tokens, err := Client.Tokens()
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+
+		var names []string
+		for name := range tokens {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var table Table
+		table = append(table, []string{"Name", "Scope", "Services", "Created"})
+		for _, name := range names {
+			token := tokens[name]
+			services := "all"
+			if len(token.Services) > 0 {
+				services = strings.Join(token.Services, ",")
+			}
+
+			table = append(
+				table,
+				[]string{
+					name,
+					string(token.Scope),
+					services,
+					token.Created.Format("2006-01-02 15:04"),
+				},
+			)
+		}
+		table.Print()
+	},
+}
+
+var tokensCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create an API token",
+	Long:  `Create an API token, provide the name as the first argument. The token is only shown once.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		scope, _ := cmd.Flags().GetString("scope")
+		services, _ := cmd.Flags().GetStringSlice("service")
+
+		if !config.Scope(scope).Valid() {
+			fmt.Printf("Invalid scope: %s, use read, deploy or admin\n", scope)
+			return
+		}
+
+		token, err := Client.CreateToken(args[0], config.Scope(scope), services)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+
+		PrintlnBold("Token created, store it now as it can't be shown again:")
+		fmt.Println(token)
+	},
+}
+
+var tokensRevokeCmd = &cobra.Command{
+	Use:   "revoke",
+	Short: "Revoke an API token",
+	Long:  `Revoke an API token, provide the name as the first argument.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := Client.RevokeToken(args[0])
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+
+		fmt.Println("Token revoked")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tokensCmd)
+	tokensCmd.AddCommand(tokensListCmd)
+	tokensCmd.AddCommand(tokensCreateCmd)
+	tokensCmd.AddCommand(tokensRevokeCmd)
+	tokensCreateCmd.Flags().String("scope", string(config.ScopeRead), "access level: read, deploy or admin")
+	tokensCreateCmd.Flags().StringSlice("service", nil, "restrict the token to a service, may be repeated")
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"hotify/pkg/config"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate a server config file",
+	Long:  `Validate a hotify server config file, provide the path as the first argument. Every problem is reported with its TOML key.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var serverConfig config.Config
+		err := serverConfig.Load(args[0])
+
+		var validationErrors config.ValidationErrors
+		switch {
+		case err == nil:
+			fmt.Println("Config is valid")
+			return
+		case errors.As(err, &validationErrors):
+			PrintlnBold(fmt.Sprintf("Found %d problems:", len(validationErrors)))
+			for _, validationError := range validationErrors {
+				fmt.Printf("  %s\n", validationError)
+			}
+		default:
+			fmt.Printf("Error: %s\n", err)
+		}
+		os.Exit(1)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+}
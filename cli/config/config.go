@@ -9,6 +9,8 @@ import (
 type Config struct {
 	Address string
 	Secret  string
+	// Scoped API token, used instead of the secret if set
+	Token string
 }
 
 func (c *Config) Load(path string) error {
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"fmt"
+	"hotify/pkg/audit"
+	"hotify/pkg/services"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// actor describes who sent the request for the audit log
+func actor(c echo.Context) string {
+	if name, ok := c.Get(tokenNameKey).(string); ok {
+		return "token:" + name
+	}
+	return "secret"
+}
+
+// deployedCommit returns the commit of the service's current deployment, if any
+func deployedCommit(service *services.Service) string {
+	if service == nil || service.History == nil {
+		return ""
+	}
+
+	deployment := service.History.Get(service.History.Current)
+	if deployment == nil {
+		return ""
+	}
+	return deployment.Commit
+}
+
+// record adds an entry to the audit log, filling in actor, IP and result. The IP only
+// comes from forwarded headers if the request was sent by a trusted proxy, see main.
+// Failing to write the audit log is logged but doesn't fail the request.
+func (s *Server) record(c echo.Context, entry audit.Entry, err error) {
+	if entry.Actor == "" {
+		entry.Actor = actor(c)
+	}
+	entry.IP = c.RealIP()
+	if entry.Result == "" {
+		entry.Result = audit.ResultSuccess
+		if err != nil {
+			entry.Result = audit.ResultFailure
+			entry.Error = err.Error()
+		}
+	}
+
+	recordErr := s.Audit.Record(entry)
+	if recordErr != nil {
+		slog.Error("Failed to write audit log", "error", recordErr)
+	}
+}
+
+// DenialInterval is the minimum time between audit entries for requests from one IP that
+// failed to authenticate, the denials in between are counted in the next entry
+const DenialInterval = time.Minute
+
+// maximum number of IPs whose denials are counted, further IPs aren't recorded until entries expire
+const maxDenialIPs = 10000
+
+type denialCount struct {
+	recorded   time.Time
+	suppressed int
+}
+
+// denialLimiter collapses unauthenticated denials per IP, so they can't fill the audit log
+type denialLimiter struct {
+	mu  sync.Mutex
+	ips map[string]*denialCount
+}
+
+func newDenialLimiter() *denialLimiter {
+	return &denialLimiter{
+		ips: map[string]*denialCount{},
+	}
+}
+
+// allow reports whether a denial from ip is recorded now, and how many were suppressed before it
+func (d *denialLimiter) allow(ip string, now time.Time) (bool, int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	count, ok := d.ips[ip]
+	if ok && now.Sub(count.recorded) < DenialInterval {
+		count.suppressed++
+		return false, 0
+	}
+
+	if !ok && len(d.ips) >= maxDenialIPs {
+		for key, count := range d.ips {
+			if now.Sub(count.recorded) >= DenialInterval {
+				delete(d.ips, key)
+			}
+		}
+		if len(d.ips) >= maxDenialIPs {
+			return false, 0
+		}
+	}
+
+	suppressed := 0
+	if ok {
+		suppressed = count.suppressed
+	}
+	d.ips[ip] = &denialCount{recorded: now}
+
+	return true, suppressed
+}
+
+// recordDenied adds a denied entry to the audit log. Requests that failed to
+// authenticate are recorded at most once per DenialInterval and IP.
+func (s *Server) recordDenied(c echo.Context, entry audit.Entry, authenticated bool) {
+	entry.Result = audit.ResultDenied
+
+	if !authenticated {
+		ok, suppressed := s.denials.allow(c.RealIP(), time.Now())
+		if !ok {
+			return
+		}
+		if suppressed > 0 {
+			entry.Detail = strings.TrimSpace(fmt.Sprintf("%s (%d similar denials suppressed)", entry.Detail, suppressed))
+		}
+	}
+
+	s.record(c, entry, nil)
+}
+
+// deny records a rejected request in the audit log and responds with 403
+func (s *Server) deny(c echo.Context, actor string, reason string, authenticated bool) error {
+	s.recordDenied(c, audit.Entry{
+		Actor:   actor,
+		Action:  "request",
+		Service: c.Param("service"),
+		Detail:  c.Request().Method + " " + c.Request().URL.Path,
+		Error:   reason,
+	}, authenticated)
+
+	return c.JSON(http.StatusForbidden, nil)
+}
+
+// GetAudit returns the newest audit entries, filtered by the actor, action,
+// service, result and since query parameters. limit defaults to 100.
+func (s *Server) GetAudit(c echo.Context) error {
+	var filter audit.Filter
+	var result, since string
+	limit := 100
+	err := echo.QueryParamsBinder(c).
+		String("actor", &filter.Actor).
+		String("action", &filter.Action).
+		String("service", &filter.Service).
+		String("result", &result).
+		String("since", &since).
+		Int("limit", &limit).
+		BindError()
+	if err != nil || limit < 0 {
+		return c.JSON(http.StatusBadRequest, nil)
+	}
+	filter.Result = audit.Result(result)
+	if since != "" {
+		filter.Since, err = ParseSince(since)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, nil)
+		}
+	}
+
+	entries, err := s.Audit.Query(filter, limit)
+	if err != nil {
+		slog.Error("Failed to read audit log", "error", err)
+		return c.JSON(http.StatusInternalServerError, nil)
+	}
+
+	return c.JSON(http.StatusOK, entries)
+}
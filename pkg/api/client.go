@@ -1,34 +1,68 @@
 package api
 
 import (
+	"bufio"
 	"bytes"
-	"crypto/hmac"
-	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"hotify/pkg/audit"
 	"hotify/pkg/config"
+	"hotify/pkg/logs"
+	"hotify/pkg/proxy"
 	"hotify/pkg/services"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
 )
 
 func ResponseOK(resp *http.Response) bool {
 	return resp.StatusCode >= 200 && resp.StatusCode < 300
 }
 
+// responseError reads the error of a failed response,
+// validation errors are returned as config.ValidationErrors
+func responseError(resp *http.Response) error {
+	var body []byte
+	if resp.Body != nil {
+		body, _ = io.ReadAll(resp.Body)
+	}
+
+	if resp.StatusCode == http.StatusBadRequest {
+		var validationErrors config.ValidationErrors
+		if json.Unmarshal(body, &validationErrors) == nil && len(validationErrors) > 0 {
+			return validationErrors
+		}
+	}
+
+	return fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, body)
+}
+
 type Client struct {
 	Address string
 	Secret  string
-	client  *http.Client
+	// Scoped API token, used instead of the secret if set
+	Token  string
+	client *http.Client
 }
 
-// custom round tripper for computing the HMAC signature
+// custom round tripper for signing requests with a timestamp and nonce
 type roundTripper struct {
 	secret string
+	token  string
 	rt     http.RoundTripper
 }
 
 func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rt.token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+rt.token)
+		return rt.rt.RoundTrip(req)
+	}
+
 	if req.Body == nil {
 		req.Body = io.NopCloser(bytes.NewReader([]byte{}))
 	}
@@ -39,10 +73,15 @@ func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	}
 	req.Body = io.NopCloser(bytes.NewReader(body))
 
-	signature := hmac.New(sha256.New, []byte(rt.secret))
-	signature.Write(body)
+	nonce, err := NewNonce()
+	if err != nil {
+		return nil, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
 
-	req.Header.Set("X-Signature-256", fmt.Sprintf("sha256=%x", signature.Sum(nil)))
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(NonceHeader, nonce)
+	req.Header.Set(SignatureHeader, SignRequest(rt.secret, timestamp, nonce, req.Method, req.URL.RequestURI(), body))
 
 	return rt.rt.RoundTrip(req)
 }
@@ -62,6 +101,22 @@ func NewClient(address, secret string) *Client {
 	}
 }
 
+// NewTokenClient creates a client authenticating with a scoped API token
+func NewTokenClient(address, token string) *Client {
+	client := &http.Client{
+		Transport: &roundTripper{
+			token: token,
+			rt:    http.DefaultTransport,
+		},
+	}
+
+	return &Client{
+		Address: address,
+		Token:   token,
+		client:  client,
+	}
+}
+
 func (c *Client) Fetch(method string, path string) (*http.Response, error) {
 	req, err := http.NewRequest(method, fmt.Sprintf("%s/%s", c.Address, path), nil)
 	if err != nil {
@@ -73,12 +128,8 @@ func (c *Client) Fetch(method string, path string) (*http.Response, error) {
 		return nil, err
 	}
 	if !ResponseOK(resp) {
-		var body []byte
-		if resp.Body != nil {
-			defer resp.Body.Close()
-			body, _ = io.ReadAll(resp.Body)
-		}
-		return nil, fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, body)
+		defer resp.Body.Close()
+		return nil, responseError(resp)
 	}
 
 	return resp, nil
@@ -100,14 +151,14 @@ func (c *Client) GetConfig() (*config.Config, error) {
 	return &config, nil
 }
 
-func (c *Client) Services() ([]services.Service, error) {
+func (c *Client) Services() ([]*services.Service, error) {
 	resp, err := c.Fetch(http.MethodGet, "api/services")
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	var services []services.Service
+	var services []*services.Service
 	err = json.NewDecoder(resp.Body).Decode(&services)
 	if err != nil {
 		return nil, err
@@ -116,6 +167,22 @@ func (c *Client) Services() ([]services.Service, error) {
 	return services, nil
 }
 
+func (c *Client) InitProgress() (*services.InitProgress, error) {
+	resp, err := c.Fetch(http.MethodGet, "api/init")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var progress services.InitProgress
+	err = json.NewDecoder(resp.Body).Decode(&progress)
+	if err != nil {
+		return nil, err
+	}
+
+	return &progress, nil
+}
+
 func (c *Client) Service(name string) (*services.Service, error) {
 	resp, err := c.Fetch(http.MethodGet, fmt.Sprintf("api/services/%s", name))
 	if err != nil {
@@ -174,7 +241,7 @@ func (c *Client) CreateService(config *config.ServiceConfig) error {
 	}
 	defer resp.Body.Close()
 	if !ResponseOK(resp) {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return responseError(resp)
 	}
 
 	return nil
@@ -199,3 +266,273 @@ func (c *Client) RestartService(name string) error {
 
 	return nil
 }
+
+func (c *Client) SetServiceEnv(name string, env *ServiceEnv) error {
+	marshaled, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/api/services/%s/env", c.Address, name), bytes.NewReader(marshaled))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if !ResponseOK(resp) {
+		return responseError(resp)
+	}
+
+	return nil
+}
+
+// EditService sends a JSON merge patch for the service config and returns the new config
+func (c *Client) EditService(name string, patch any) (*config.ServiceConfig, error) {
+	marshaled, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPatch, fmt.Sprintf("%s/api/services/%s", c.Address, name), bytes.NewReader(marshaled))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if !ResponseOK(resp) {
+		return nil, responseError(resp)
+	}
+
+	var serviceConfig config.ServiceConfig
+	err = json.NewDecoder(resp.Body).Decode(&serviceConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &serviceConfig, nil
+}
+
+func (c *Client) Deployments(name string) (*services.DeploymentHistory, error) {
+	resp, err := c.Fetch(http.MethodGet, fmt.Sprintf("api/services/%s/deployments", name))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var history services.DeploymentHistory
+	err = json.NewDecoder(resp.Body).Decode(&history)
+	if err != nil {
+		return nil, err
+	}
+
+	return &history, nil
+}
+
+func (c *Client) RollbackService(name string, deployment int) error {
+	resp, err := c.Fetch(http.MethodPost, fmt.Sprintf("api/services/%s/rollback/%d", name, deployment))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
+
+// Logs fetches a page of log entries, params may contain offset, limit, stream, phase, deployment and since.
+func (c *Client) Logs(name string, params url.Values) (*LogPage, error) {
+	resp, err := c.Fetch(http.MethodGet, fmt.Sprintf("api/services/%s/logs?%s", name, params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var page LogPage
+	err = json.NewDecoder(resp.Body).Decode(&page)
+	if err != nil {
+		return nil, err
+	}
+
+	return &page, nil
+}
+
+// StreamLogs calls handler for every log entry sent by the stream endpoint until the stream ends.
+// params may contain after, tail, stream, phase, deployment and since.
+func (c *Client) StreamLogs(name string, params url.Values, handler func(logs.Entry)) error {
+	resp, err := c.Fetch(http.MethodGet, fmt.Sprintf("api/services/%s/logs/stream?%s", name, params.Encode()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+
+		var entry logs.Entry
+		err := json.Unmarshal([]byte(data), &entry)
+		if err != nil {
+			return err
+		}
+		handler(entry)
+	}
+
+	return scanner.Err()
+}
+
+func (c *Client) Tokens() (map[string]*config.TokenConfig, error) {
+	resp, err := c.Fetch(http.MethodGet, "api/tokens")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var tokens map[string]*config.TokenConfig
+	err = json.NewDecoder(resp.Body).Decode(&tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// CreateToken creates a scoped API token and returns it, it can't be retrieved again
+func (c *Client) CreateToken(name string, scope config.Scope, services []string) (string, error) {
+	marshaled, err := json.Marshal(CreateTokenRequest{
+		Name:     name,
+		Scope:    scope,
+		Services: services,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.client.Post(fmt.Sprintf("%s/api/tokens", c.Address), "application/json", bytes.NewReader(marshaled))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if !ResponseOK(resp) {
+		return "", responseError(resp)
+	}
+
+	var created CreateTokenResponse
+	err = json.NewDecoder(resp.Body).Decode(&created)
+	if err != nil {
+		return "", err
+	}
+
+	return created.Token, nil
+}
+
+func (c *Client) RevokeToken(name string) error {
+	resp, err := c.Fetch(http.MethodDelete, fmt.Sprintf("api/tokens/%s", name))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
+
+// Audit fetches audit log entries, params may contain actor, action, service, result, since and limit
+func (c *Client) Audit(params url.Values) ([]audit.Entry, error) {
+	resp, err := c.Fetch(http.MethodGet, fmt.Sprintf("api/audit?%s", params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var entries []audit.Entry
+	err = json.NewDecoder(resp.Body).Decode(&entries)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Reload makes the server read its config file again and apply the changes
+func (c *Client) Reload() error {
+	resp, err := c.Fetch(http.MethodPost, "api/reload")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
+
+func (c *Client) ConfigBackups() ([]config.Backup, error) {
+	resp, err := c.Fetch(http.MethodGet, "api/config/backups")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var backups []config.Backup
+	err = json.NewDecoder(resp.Body).Decode(&backups)
+	if err != nil {
+		return nil, err
+	}
+
+	return backups, nil
+}
+
+func (c *Client) ProxyRoutes() ([]proxy.Route, error) {
+	resp, err := c.Fetch(http.MethodGet, "api/proxy/routes")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var routes []proxy.Route
+	err = json.NewDecoder(resp.Body).Decode(&routes)
+	if err != nil {
+		return nil, err
+	}
+
+	return routes, nil
+}
+
+// ReconcileProxy fixes the proxy routes of the server and returns the changes,
+// with dryRun the changes are only returned
+func (c *Client) ReconcileProxy(dryRun bool) ([]proxy.Change, error) {
+	resp, err := c.Fetch(http.MethodPost, fmt.Sprintf("api/proxy/reconcile?dryRun=%t", dryRun))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var changes []proxy.Change
+	err = json.NewDecoder(resp.Body).Decode(&changes)
+	if err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// RestoreConfigBackup replaces the server config with a backup and reloads it
+func (c *Client) RestoreConfigBackup(name string) error {
+	resp, err := c.Fetch(http.MethodPost, fmt.Sprintf("api/config/backups/%s/restore", name))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
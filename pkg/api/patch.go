@@ -0,0 +1,25 @@
+package api
+
+// mergePatch applies a JSON merge patch (RFC 7396) to a decoded JSON document.
+// Objects are merged recursively, null removes a key and everything else replaces the target.
+func mergePatch(target any, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergePatch(targetObject[key], value)
+	}
+
+	return targetObject
+}
@@ -4,17 +4,27 @@ import (
 	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hotify/pkg/audit"
 	"hotify/pkg/config"
+	"hotify/pkg/git"
+	"hotify/pkg/logs"
 	"hotify/pkg/services"
 	"io"
 	"log/slog"
 	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
 
+// VerifyRequest checks a webhook signature computed over the body only
 func VerifyRequest(body []byte, signatureHeader string, secret string) bool {
 	signature := hmac.New(sha256.New, []byte(secret))
 	signature.Write([]byte(body))
@@ -27,13 +37,25 @@ type Server struct {
 	Config  *config.Config
 	Manager *services.Manager
 	Group   *echo.Group
+	Audit   *audit.Log
+
+	nonces  *nonceCache
+	denials *denialLimiter
 }
 
-func NewServer(config *config.Config, manager *services.Manager, group *echo.Group) *Server {
+func NewServer(conf *config.Config, manager *services.Manager, group *echo.Group) *Server {
+	auditPath := conf.AuditPath
+	if auditPath == "" {
+		auditPath = filepath.Join(conf.ServicesPath, "audit.log")
+	}
+
 	s := &Server{
-		Config:  config,
+		Config:  conf,
 		Manager: manager,
 		Group:   group,
+		Audit:   audit.NewLog(auditPath),
+		nonces:  newNonceCache(),
+		denials: newDenialLimiter(),
 	}
 
 	// auth middleware
@@ -44,14 +66,33 @@ func NewServer(config *config.Config, manager *services.Manager, group *echo.Gro
 				return next(c)
 			}
 
+			// scoped tokens are sent as bearer tokens instead of signing the request
+			if token, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer "); ok {
+				if !s.authenticateToken(c, token) {
+					slog.Warn("Rejected API request", "path", c.Request().URL.Path, "ip", c.RealIP(), "error", "unknown token")
+					return s.deny(c, "token", "unknown token", false)
+				}
+				return next(c)
+			}
+
 			body, err := io.ReadAll(c.Request().Body)
 			if err != nil {
 				slog.Error("Failed to read body", "error", err)
 				return c.JSON(http.StatusInternalServerError, nil)
 			}
 
-			if !VerifyRequest(body, c.Request().Header.Get("X-Signature-256"), s.Config.Secret) {
-				return c.JSON(http.StatusForbidden, nil)
+			req := c.Request()
+			err = s.verifySignedRequest(
+				req.Header.Get(SignatureHeader),
+				req.Header.Get(TimestampHeader),
+				req.Header.Get(NonceHeader),
+				req.Method,
+				req.URL.RequestURI(),
+				body,
+			)
+			if err != nil {
+				slog.Warn("Rejected API request", "path", req.URL.Path, "ip", c.RealIP(), "error", err)
+				return s.deny(c, "secret", err.Error(), false)
 			}
 
 			c.Request().Body = io.NopCloser(bytes.NewReader(body))
@@ -60,18 +101,47 @@ func NewServer(config *config.Config, manager *services.Manager, group *echo.Gro
 		}
 	})
 
-	s.Group.GET("/config", s.GetConfig)
+	read := s.require(config.ScopeRead)
+	deploy := s.require(config.ScopeDeploy)
+	admin := s.require(config.ScopeAdmin)
+	// lists are filtered by the handlers, so tokens limited to some services can use them
+	list := s.requireScope(config.ScopeRead)
+
+	s.Group.GET("/config", s.GetConfig, admin)
+	s.Group.POST("/reload", s.ReloadConfig, admin)
+	s.Group.GET("/config/backups", s.GetConfigBackups, admin)
+	s.Group.POST("/config/backups/:backup/restore", s.RestoreConfigBackup, admin)
+
+	s.Group.GET("/proxy/routes", s.GetProxyRoutes, admin)
+	s.Group.POST("/proxy/reconcile", s.ReconcileProxy, admin)
+
+	s.Group.GET("/init", s.GetInitProgress, list)
+
+	s.Group.GET("/services", s.GetServices, list)
+	s.Group.POST("/services", s.CreateService, admin)
+
+	s.Group.GET("/services/:service", s.GetService, read)
+	s.Group.PATCH("/services/:service", s.EditService, admin)
+	s.Group.DELETE("/services/:service", s.DeleteService, admin)
+
+	s.Group.PUT("/services/:service/env", s.SetServiceEnv, admin)
+
+	s.Group.GET("/services/:service/start", s.StartService, deploy)
+	s.Group.GET("/services/:service/stop", s.StopService, deploy)
+	s.Group.GET("/services/:service/update", s.UpdateService, deploy)
+	s.Group.GET("/services/:service/restart", s.RestartService, deploy)
+
+	s.Group.GET("/services/:service/logs", s.GetLogs, read)
+	s.Group.GET("/services/:service/logs/stream", s.StreamLogs, read)
 
-	s.Group.GET("/services", s.GetServices)
-	s.Group.POST("/services", s.CreateService)
+	s.Group.GET("/services/:service/deployments", s.GetDeployments, read)
+	s.Group.POST("/services/:service/rollback/:deployment", s.RollbackService, deploy)
 
-	s.Group.GET("/services/:service", s.GetService)
-	s.Group.DELETE("/services/:service", s.DeleteService)
+	s.Group.GET("/tokens", s.GetTokens, admin)
+	s.Group.POST("/tokens", s.CreateToken, admin)
+	s.Group.DELETE("/tokens/:token", s.RevokeToken, admin)
 
-	s.Group.GET("/services/:service/start", s.StartService)
-	s.Group.GET("/services/:service/stop", s.StopService)
-	s.Group.GET("/services/:service/update", s.UpdateService)
-	s.Group.GET("/services/:service/restart", s.RestartService)
+	s.Group.GET("/audit", s.GetAudit, admin)
 
 	s.Group.POST("/services/:service/webhook", s.ServiceWebhook)
 
@@ -79,13 +149,119 @@ func NewServer(config *config.Config, manager *services.Manager, group *echo.Gro
 }
 
 func (s *Server) GetConfig(c echo.Context) error {
-	return c.JSON(http.StatusOK, s.Config)
+	return c.JSON(http.StatusOK, s.Manager.RedactedConfig())
+}
+
+// ReloadConfig reads the config file again and applies the changes to the services
+func (s *Server) ReloadConfig(c echo.Context) error {
+	err := s.Manager.Reload()
+	s.record(c, audit.Entry{Action: "reload"}, err)
+	if errors.Is(err, services.ErrInitializing) {
+		return c.JSON(http.StatusConflict, nil)
+	}
+	if err != nil {
+		slog.Error("Failed to reload config", "error", err)
+		return c.JSON(http.StatusInternalServerError, nil)
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+func (s *Server) GetConfigBackups(c echo.Context) error {
+	backups, err := config.ListBackups(s.Config.LoadPath)
+	if err != nil {
+		slog.Error("Failed to list config backups", "error", err)
+		return c.JSON(http.StatusInternalServerError, nil)
+	}
+
+	return c.JSON(http.StatusOK, backups)
+}
+
+// GetProxyRoutes returns the routes configured in the reverse proxy
+func (s *Server) GetProxyRoutes(c echo.Context) error {
+	routes, err := s.Manager.Proxy.Routes()
+	if err != nil {
+		slog.Error("Failed to list proxy routes", "error", err)
+		return c.JSON(http.StatusInternalServerError, nil)
+	}
+
+	return c.JSON(http.StatusOK, routes)
+}
+
+// ReconcileProxy fixes the proxy routes and returns the changes,
+// with dryRun=true the changes are only returned
+func (s *Server) ReconcileProxy(c echo.Context) error {
+	var dryRun bool
+	if err := echo.QueryParamsBinder(c).Bool("dryRun", &dryRun).BindError(); err != nil {
+		return c.JSON(http.StatusBadRequest, nil)
+	}
+
+	changes, err := s.Manager.ReconcileProxy(dryRun)
+	if !dryRun {
+		s.record(c, audit.Entry{
+			Action: "proxy.reconcile",
+			Detail: fmt.Sprintf("%d changes", len(changes)),
+		}, err)
+	}
+	if err != nil {
+		slog.Error("Failed to reconcile proxy routes", "error", err)
+		return c.JSON(http.StatusInternalServerError, nil)
+	}
+
+	return c.JSON(http.StatusOK, changes)
+}
+
+// RestoreConfigBackup replaces the config with a backup and reloads it
+func (s *Server) RestoreConfigBackup(c echo.Context) error {
+	backups, err := config.ListBackups(s.Config.LoadPath)
+	if err != nil {
+		slog.Error("Failed to list config backups", "error", err)
+		return c.JSON(http.StatusInternalServerError, nil)
+	}
+	name := c.Param("backup")
+	if !slices.ContainsFunc(backups, func(backup config.Backup) bool { return backup.Name == name }) {
+		return c.JSON(http.StatusNotFound, nil)
+	}
+
+	err = s.Manager.RestoreBackup(name)
+	s.record(c, audit.Entry{Action: "config.restore", Detail: name}, err)
+	if errors.Is(err, services.ErrInitializing) {
+		return c.JSON(http.StatusConflict, nil)
+	}
+
+	var validationErrors config.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		return c.JSON(http.StatusBadRequest, validationErrors)
+	}
+	if err != nil {
+		slog.Error("Failed to restore config backup", "error", err)
+		return c.JSON(http.StatusInternalServerError, nil)
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+// GetInitProgress returns the progress of the service initialization at startup
+func (s *Server) GetInitProgress(c echo.Context) error {
+	progress := s.Manager.InitProgress()
+	for name := range progress.Services {
+		if !allowsService(c, name) {
+			delete(progress.Services, name)
+		}
+	}
+
+	return c.JSON(http.StatusOK, progress)
 }
 
 func (s *Server) GetServices(c echo.Context) error {
-	services := s.Manager.Services()
+	visible := []*services.Service{}
+	for _, service := range s.Manager.Services() {
+		if allowsService(c, service.Config.Name) {
+			visible = append(visible, service)
+		}
+	}
 
-	return c.JSON(http.StatusOK, services)
+	return c.JSON(http.StatusOK, visible)
 }
 
 func (s *Server) GetService(c echo.Context) error {
@@ -109,8 +285,16 @@ func (s *Server) CreateService(c echo.Context) error {
 	if s.Manager.Service(serviceConfig.Name) != nil {
 		return c.JSON(http.StatusConflict, nil)
 	}
+	if err := s.Config.ValidateService(&serviceConfig); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
 
 	err := s.Manager.Create(&serviceConfig)
+	s.record(c, audit.Entry{
+		Action:  "create",
+		Service: serviceConfig.Name,
+		Commit:  deployedCommit(s.Manager.Service(serviceConfig.Name)),
+	}, err)
 	if err != nil {
 		slog.Error("Failed to create service", "error", err)
 		return c.JSON(http.StatusInternalServerError, nil)
@@ -119,6 +303,88 @@ func (s *Server) CreateService(c echo.Context) error {
 	return c.JSON(http.StatusOK, nil)
 }
 
+// EditService applies a JSON merge patch (RFC 7396) to the service config,
+// eg. {"exec": "./server", "env": {"OLD": null}}, and returns the new config
+func (s *Server) EditService(c echo.Context) error {
+	service := s.Manager.Service(c.Param("service"))
+	if service == nil {
+		return c.JSON(http.StatusNotFound, nil)
+	}
+
+	var patch any
+	if err := json.NewDecoder(c.Request().Body).Decode(&patch); err != nil {
+		return c.JSON(http.StatusBadRequest, nil)
+	}
+
+	current, err := json.Marshal(service.Config)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, nil)
+	}
+	var document any
+	if err := json.Unmarshal(current, &document); err != nil {
+		return c.JSON(http.StatusInternalServerError, nil)
+	}
+	merged, err := json.Marshal(mergePatch(document, patch))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, nil)
+	}
+
+	var serviceConfig config.ServiceConfig
+	if err := json.Unmarshal(merged, &serviceConfig); err != nil {
+		return c.JSON(http.StatusBadRequest, nil)
+	}
+	if serviceConfig.Name != service.Config.Name {
+		return c.JSON(http.StatusBadRequest, config.ValidationErrors{{Key: "Name", Message: "can't be changed"}})
+	}
+	if err := s.Config.ValidateService(&serviceConfig); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	err = s.Manager.Edit(service.Config.Name, &serviceConfig)
+	s.record(c, audit.Entry{
+		Action:  "edit",
+		Service: service.Config.Name,
+		Commit:  deployedCommit(service),
+	}, err)
+	if err != nil {
+		slog.Error("Failed to edit service", "error", err)
+		return c.JSON(http.StatusInternalServerError, nil)
+	}
+
+	return c.JSON(http.StatusOK, service.Config.Redacted())
+}
+
+type ServiceEnv struct {
+	Env       map[string]string `json:"env"`
+	EnvFile   []string          `json:"envFile"`
+	SecretEnv []string          `json:"secretEnv"`
+}
+
+func (s *Server) SetServiceEnv(c echo.Context) error {
+	service := s.Manager.Service(c.Param("service"))
+	if service == nil {
+		return c.JSON(http.StatusNotFound, nil)
+	}
+
+	var env ServiceEnv
+	if err := c.Bind(&env); err != nil {
+		return c.JSON(http.StatusBadRequest, nil)
+	}
+
+	err := s.Manager.SetEnv(service.Config.Name, env.Env, env.EnvFile, env.SecretEnv)
+	s.record(c, audit.Entry{Action: "env", Service: service.Config.Name}, err)
+	var validationErrors config.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		return c.JSON(http.StatusBadRequest, validationErrors)
+	}
+	if err != nil {
+		slog.Error("Failed to set service env", "error", err)
+		return c.JSON(http.StatusInternalServerError, nil)
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
+
 func (s *Server) StartService(c echo.Context) error {
 	service := s.Manager.Service(c.Param("service"))
 	if service == nil {
@@ -126,6 +392,11 @@ func (s *Server) StartService(c echo.Context) error {
 	}
 
 	err := service.Start()
+	s.record(c, audit.Entry{
+		Action:  "start",
+		Service: service.Config.Name,
+		Commit:  deployedCommit(service),
+	}, err)
 	if err != nil {
 		slog.Error("Failed to start service", "error", err)
 		return c.JSON(http.StatusInternalServerError, nil)
@@ -141,6 +412,11 @@ func (s *Server) StopService(c echo.Context) error {
 	}
 
 	err := service.Stop()
+	s.record(c, audit.Entry{
+		Action:  "stop",
+		Service: service.Config.Name,
+		Commit:  deployedCommit(service),
+	}, err)
 	if err != nil {
 		slog.Error("Failed to stop service", "error", err)
 		return c.JSON(http.StatusInternalServerError, nil)
@@ -155,7 +431,12 @@ func (s *Server) UpdateService(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, nil)
 	}
 
-	err := service.Update()
+	err := service.Update(services.TriggerAPI)
+	s.record(c, audit.Entry{
+		Action:  "update",
+		Service: service.Config.Name,
+		Commit:  deployedCommit(service),
+	}, err)
 	if err != nil {
 		slog.Error("Failed to update service", "error", err)
 		return c.JSON(http.StatusInternalServerError, nil)
@@ -164,13 +445,249 @@ func (s *Server) UpdateService(c echo.Context) error {
 	return c.JSON(http.StatusOK, nil)
 }
 
+type LogPage struct {
+	Entries []logs.Entry `json:"entries"`
+	// Whether older entries exist
+	More bool `json:"more"`
+}
+
+// bindLogFilter reads the stream, phase, deployment and since query parameters
+func bindLogFilter(c echo.Context) (logs.Filter, error) {
+	var filter logs.Filter
+	var stream, phase, since string
+	err := echo.QueryParamsBinder(c).
+		String("stream", &stream).
+		String("phase", &phase).
+		Int("deployment", &filter.Deployment).
+		String("since", &since).
+		BindError()
+	if err != nil {
+		return filter, err
+	}
+
+	switch logs.Stream(stream) {
+	case "", logs.StreamStdout, logs.StreamStderr:
+		filter.Stream = logs.Stream(stream)
+	default:
+		return filter, fmt.Errorf("invalid stream: %s", stream)
+	}
+
+	switch logs.Phase(phase) {
+	case "", logs.PhaseBuild, logs.PhaseRun:
+		filter.Phase = logs.Phase(phase)
+	default:
+		return filter, fmt.Errorf("invalid phase: %s", phase)
+	}
+
+	if since != "" {
+		filter.Since, err = ParseSince(since)
+		if err != nil {
+			return filter, err
+		}
+	}
+
+	return filter, nil
+}
+
+// GetLogs pages through the log files, offset counts entries from the newest entry
+func (s *Server) GetLogs(c echo.Context) error {
+	service := s.Manager.Service(c.Param("service"))
+	if service == nil {
+		return c.JSON(http.StatusNotFound, nil)
+	}
+
+	offset, limit := 0, 100
+	if err := echo.QueryParamsBinder(c).Int("offset", &offset).Int("limit", &limit).BindError(); err != nil {
+		return c.JSON(http.StatusBadRequest, nil)
+	}
+	if offset < 0 || limit <= 0 {
+		return c.JSON(http.StatusBadRequest, nil)
+	}
+	filter, err := bindLogFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, nil)
+	}
+
+	entries, more, err := service.Logs.Page(offset, limit, filter)
+	if err != nil {
+		slog.Error("Failed to read logs", "error", err)
+		return c.JSON(http.StatusInternalServerError, nil)
+	}
+
+	return c.JSON(http.StatusOK, LogPage{
+		Entries: entries,
+		More:    more,
+	})
+}
+
+// ParseSince parses an RFC 3339 timestamp or a duration relative to now, eg. 10m
+func ParseSince(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time or duration: %s", value)
+	}
+
+	return time.Now().Add(-duration), nil
+}
+
+// StreamLogs sends log entries as server-sent events, starting with entries kept in memory.
+// The backlog is selected by after (sequence number, also read from Last-Event-ID)
+// or tail (number of entries, defaults to 100). Entries can be filtered by
+// stream, phase, deployment and since (timestamp or duration).
+func (s *Server) StreamLogs(c echo.Context) error {
+	service := s.Manager.Service(c.Param("service"))
+	if service == nil {
+		return c.JSON(http.StatusNotFound, nil)
+	}
+
+	var after uint64
+	tail := 100
+	if id := c.Request().Header.Get("Last-Event-ID"); id != "" {
+		c.QueryParams().Set("after", id)
+	}
+	err := echo.QueryParamsBinder(c).
+		Uint64("after", &after).
+		Int("tail", &tail).
+		BindError()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, nil)
+	}
+	filter, err := bindLogFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, nil)
+	}
+
+	// subscribe before reading the backlog so no entry is missed
+	sub := service.Logs.Subscribe(1024)
+	defer sub.Close()
+
+	var backlog []logs.Entry
+	switch {
+	case after > 0 || c.QueryParam("since") != "":
+		backlog = service.Logs.Recent(after, filter)
+	default:
+		backlog = service.Logs.Tail(tail, filter)
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.WriteHeader(http.StatusOK)
+
+	var last uint64
+	send := func(entry logs.Entry) error {
+		if entry.Seq <= last || !filter.Match(entry) {
+			return nil
+		}
+		last = entry.Seq
+
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(res, "id: %d\ndata: %s\n\n", entry.Seq, data)
+		return err
+	}
+
+	for _, entry := range backlog {
+		if err := send(entry); err != nil {
+			return nil
+		}
+	}
+	res.Flush()
+
+	ping := time.NewTicker(15 * time.Second)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-ping.C:
+			_, err = fmt.Fprint(res, ": ping\n\n")
+		case entry, ok := <-sub.Entries:
+			if !ok {
+				// closed because the client fell behind, it resumes with Last-Event-ID
+				return nil
+			}
+			err = send(entry)
+		}
+		if err != nil {
+			return nil
+		}
+		res.Flush()
+	}
+}
+
+func (s *Server) GetDeployments(c echo.Context) error {
+	service := s.Manager.Service(c.Param("service"))
+	if service == nil {
+		return c.JSON(http.StatusNotFound, nil)
+	}
+
+	return c.JSON(http.StatusOK, service.History)
+}
+
+func (s *Server) RollbackService(c echo.Context) error {
+	service := s.Manager.Service(c.Param("service"))
+	if service == nil {
+		return c.JSON(http.StatusNotFound, nil)
+	}
+
+	id, err := strconv.Atoi(c.Param("deployment"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, nil)
+	}
+	// only deployments that were built successfully can be restored
+	if deployment := service.History.Get(id); deployment == nil || deployment.State != services.DeploymentStateDeployed {
+		return c.JSON(http.StatusNotFound, nil)
+	}
+
+	err = service.Rollback(id)
+	s.record(c, audit.Entry{
+		Action:  "rollback",
+		Service: service.Config.Name,
+		Detail:  c.Param("deployment"),
+		Commit:  deployedCommit(service),
+	}, err)
+	if err != nil {
+		slog.Error("Failed to roll back service", "error", err)
+		return c.JSON(http.StatusInternalServerError, nil)
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
+
 func (s *Server) DeleteService(c echo.Context) error {
 	service := s.Manager.Service(c.Param("service"))
 	if service == nil {
 		return c.JSON(http.StatusNotFound, nil)
 	}
 
+	// services other services depend on can't be deleted
+	var validationErrors config.ValidationErrors
+	for _, dependent := range s.Config.Dependents(service.Config.Name) {
+		validationErrors = append(validationErrors, config.ValidationError{
+			Key:     "Services." + dependent + ".DependsOn",
+			Message: fmt.Sprintf("depends on %s", service.Config.Name),
+		})
+	}
+	if len(validationErrors) > 0 {
+		return c.JSON(http.StatusBadRequest, validationErrors)
+	}
+
+	commit := deployedCommit(service)
 	err := s.Manager.Delete(service.Config.Name)
+	s.record(c, audit.Entry{
+		Action:  "delete",
+		Service: service.Config.Name,
+		Commit:  commit,
+	}, err)
 	if err != nil {
 		slog.Error("Failed to delete service", "error", err)
 		return c.JSON(http.StatusInternalServerError, nil)
@@ -186,6 +703,11 @@ func (s *Server) RestartService(c echo.Context) error {
 	}
 
 	err := service.Restart()
+	s.record(c, audit.Entry{
+		Action:  "restart",
+		Service: service.Config.Name,
+		Commit:  deployedCommit(service),
+	}, err)
 	if err != nil {
 		slog.Error("Failed to restart service", "error", err)
 		return c.JSON(http.StatusInternalServerError, nil)
@@ -194,6 +716,14 @@ func (s *Server) RestartService(c echo.Context) error {
 	return c.JSON(http.StatusOK, nil)
 }
 
+// Subset of the GitHub push event payload
+type PushEvent struct {
+	Ref        string `json:"ref"`
+	Repository struct {
+		DefaultBranch string `json:"default_branch"`
+	} `json:"repository"`
+}
+
 func (s *Server) ServiceWebhook(c echo.Context) error {
 	signatureHeader := c.Request().Header.Get("X-Hub-Signature-256")
 
@@ -203,21 +733,62 @@ func (s *Server) ServiceWebhook(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, nil)
 	}
 
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		slog.Error("Failed to read body", "error", err)
+		return c.JSON(http.StatusInternalServerError, nil)
+	}
+
 	if service.Config.Secret != "" {
-		body, err := io.ReadAll(c.Request().Body)
+		if !VerifyRequest(body, signatureHeader, service.Config.Secret) {
+			slog.Warn("Invalid signature", "service", service.Config.Name, "signature", signatureHeader)
+			s.recordDenied(c, audit.Entry{
+				Actor:   "webhook",
+				Action:  "webhook",
+				Service: service.Config.Name,
+				Error:   "invalid signature",
+			}, false)
+			return c.JSON(http.StatusForbidden, nil)
+		}
+	}
+
+	event := c.Request().Header.Get("X-GitHub-Event")
+	if event != "" && event != "push" {
+		slog.Info("Ignoring webhook event", "service", service.Config.Name, "event", event)
+		return c.JSON(http.StatusOK, nil)
+	}
+
+	// only GitHub style push events are filtered, other bodies always deploy
+	var push PushEvent
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &push)
 		if err != nil {
-			slog.Error("Failed to read body", "error", err)
-			return c.JSON(http.StatusInternalServerError, nil)
+			slog.Warn("Failed to parse webhook body, deploying anyway", "service", service.Config.Name, "error", err)
 		}
+	}
 
-		if !VerifyRequest(body, signatureHeader, service.Config.Secret) {
-			slog.Warn("Invalid signature", "service", service.Config.Name, "signature", signatureHeader, "expected", service.Config.Secret)
-			return c.JSON(http.StatusForbidden, nil)
+	defaultBranch := push.Repository.DefaultBranch
+	if push.Ref != "" && defaultBranch == "" {
+		// not every git host sends the default branch, the clone knows it
+		defaultBranch, err = git.DefaultBranch(service.RepoPath())
+		if err != nil {
+			slog.Warn("Failed to get default branch", "service", service.Config.Name, "error", err)
 		}
 	}
+	if push.Ref != "" && !service.Ref().MatchesPush(push.Ref, defaultBranch) {
+		slog.Info("Ignoring push to other ref", "service", service.Config.Name, "ref", push.Ref)
+		return c.JSON(http.StatusOK, nil)
+	}
 
 	slog.Info("Received webhook", "service", service.Config.Name)
-	err := service.Update()
+	err = service.Update(services.TriggerWebhook)
+	s.record(c, audit.Entry{
+		Actor:   "webhook",
+		Action:  "webhook",
+		Service: service.Config.Name,
+		Detail:  push.Ref,
+		Commit:  deployedCommit(service),
+	}, err)
 	if err != nil {
 		slog.Error("Failed to update service", "error", err)
 		return c.JSON(http.StatusInternalServerError, nil)
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-Signature-256"
+	TimestampHeader = "X-Timestamp"
+	NonceHeader     = "X-Nonce"
+)
+
+// MaxClockSkew is how far the timestamp of a signed request may differ from the server time
+const MaxClockSkew = 5 * time.Minute
+
+// SignRequest computes the signature header value of an API request.
+// The signed payload is the unix timestamp, nonce, method, request URI (path and query) and body,
+// separated by newlines.
+func SignRequest(secret string, timestamp string, nonce string, method string, uri string, body []byte) string {
+	signature := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(signature, "%s\n%s\n%s\n%s\n", timestamp, nonce, method, uri)
+	signature.Write(body)
+
+	return fmt.Sprintf("sha256=%x", signature.Sum(nil))
+}
+
+// NewNonce returns a random hex string used once per request
+func NewNonce() (string, error) {
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(nonce), nil
+}
+
+// nonceCache remembers the nonces of accepted requests until their timestamps are stale
+type nonceCache struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{
+		nonces: map[string]time.Time{},
+	}
+}
+
+// use records the nonce and returns false if it has been used before
+func (n *nonceCache) use(nonce string, now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for key, expires := range n.nonces {
+		if now.After(expires) {
+			delete(n.nonces, key)
+		}
+	}
+
+	if _, ok := n.nonces[nonce]; ok {
+		return false
+	}
+	// a request with this nonce is accepted until its timestamp is at most MaxClockSkew old
+	n.nonces[nonce] = now.Add(2 * MaxClockSkew)
+
+	return true
+}
+
+// verifySignedRequest checks the signature, the timestamp's age and that the nonce wasn't used before
+func (s *Server) verifySignedRequest(signatureHeader string, timestampHeader string, nonce string, method string, uri string, body []byte) error {
+	if timestampHeader == "" || nonce == "" {
+		return fmt.Errorf("missing timestamp or nonce")
+	}
+	// an empty secret would make every client able to sign requests
+	if s.Config.Secret == "" {
+		return fmt.Errorf("signed requests are disabled, no secret is configured")
+	}
+
+	expected := SignRequest(s.Config.Secret, timestampHeader, nonce, method, uri, body)
+	if !hmac.Equal([]byte(signatureHeader), []byte(expected)) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	timestamp, err := strconv.ParseInt(timestampHeader, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %s", timestampHeader)
+	}
+	now := time.Now()
+	skew := now.Sub(time.Unix(timestamp, 0))
+	if skew > MaxClockSkew || skew < -MaxClockSkew {
+		return fmt.Errorf("stale timestamp: %s", timestampHeader)
+	}
+
+	if !s.nonces.use(nonce, now) {
+		return fmt.Errorf("replayed nonce: %s", nonce)
+	}
+
+	return nil
+}
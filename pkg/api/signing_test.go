@@ -0,0 +1,187 @@
+package api
+
+import (
+	"hotify/pkg/config"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifySignedRequest(t *testing.T) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-MaxClockSkew-time.Minute).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(MaxClockSkew+time.Minute).Unix(), 10)
+	body := []byte(`{"name":"web"}`)
+
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+		timestamp string
+		nonce     string
+		valid     bool
+	}{
+		{
+			name:      "valid",
+			signature: SignRequest("secret", now, "nonce", "POST", "/api/services?dry=1", body),
+			valid:     true,
+		},
+		{
+			name:      "wrong secret",
+			signature: SignRequest("other", now, "nonce", "POST", "/api/services?dry=1", body),
+		},
+		{
+			name:      "different method",
+			signature: SignRequest("secret", now, "nonce", "GET", "/api/services?dry=1", body),
+		},
+		{
+			name:      "different query",
+			signature: SignRequest("secret", now, "nonce", "POST", "/api/services", body),
+		},
+		{
+			name:      "different body",
+			signature: SignRequest("secret", now, "nonce", "POST", "/api/services?dry=1", []byte(`{}`)),
+		},
+		{
+			name:      "different nonce",
+			signature: SignRequest("secret", now, "other", "POST", "/api/services?dry=1", body),
+		},
+		{
+			name:      "stale timestamp",
+			signature: SignRequest("secret", stale, "nonce", "POST", "/api/services?dry=1", body),
+			timestamp: stale,
+		},
+		{
+			name:      "future timestamp",
+			signature: SignRequest("secret", future, "nonce", "POST", "/api/services?dry=1", body),
+			timestamp: future,
+		},
+		{
+			name:      "invalid timestamp",
+			signature: SignRequest("secret", "yesterday", "nonce", "POST", "/api/services?dry=1", body),
+			timestamp: "yesterday",
+		},
+		{
+			name:      "missing timestamp",
+			signature: SignRequest("secret", "", "nonce", "POST", "/api/services?dry=1", body),
+			timestamp: "-",
+		},
+		{
+			name:      "missing nonce",
+			signature: SignRequest("secret", now, "", "POST", "/api/services?dry=1", body),
+			nonce:     "-",
+		},
+		{
+			name:      "empty secret",
+			secret:    "-",
+			signature: SignRequest("", now, "nonce", "POST", "/api/services?dry=1", body),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// "-" selects an empty value, unset fields use the values of the valid request
+			value := func(value string, fallback string) string {
+				switch value {
+				case "":
+					return fallback
+				case "-":
+					return ""
+				}
+				return value
+			}
+
+			s := &Server{
+				Config: &config.Config{Secret: value(test.secret, "secret")},
+				nonces: newNonceCache(),
+			}
+			err := s.verifySignedRequest(
+				test.signature,
+				value(test.timestamp, now),
+				value(test.nonce, "nonce"),
+				"POST",
+				"/api/services?dry=1",
+				body,
+			)
+			if test.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestVerifySignedRequestReplay(t *testing.T) {
+	s := &Server{
+		Config: &config.Config{Secret: "secret"},
+		nonces: newNonceCache(),
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := SignRequest("secret", timestamp, "nonce", "GET", "/api/services", nil)
+
+	err := s.verifySignedRequest(signature, timestamp, "nonce", "GET", "/api/services", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = s.verifySignedRequest(signature, timestamp, "nonce", "GET", "/api/services", nil)
+	if err == nil {
+		t.Error("expected the replayed request to be rejected")
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	cache := newNonceCache()
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		nonce string
+		time  time.Time
+		want  bool
+	}{
+		{name: "new nonce", nonce: "a", time: now, want: true},
+		{name: "other nonce", nonce: "b", time: now, want: true},
+		{name: "reused nonce", nonce: "a", time: now.Add(MaxClockSkew), want: false},
+		{name: "reused after expiry", nonce: "a", time: now.Add(2*MaxClockSkew + time.Second), want: true},
+	}
+
+	// the cases share the cache, so they run in order
+	for _, test := range tests {
+		if got := cache.use(test.nonce, test.time); got != test.want {
+			t.Errorf("%s: got %t, want %t", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSignRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		body   []byte
+		want   string
+	}{
+		{
+			name:   "without body",
+			secret: "secret",
+			want:   "sha256=0e810958422468a4f027a5442ed3a3aaa066b8c394367222e4b5fe41749cd5b2",
+		},
+		{
+			name:   "with body",
+			secret: "secret",
+			body:   []byte(`{"a":1}`),
+			want:   "sha256=ae2cd922c2be334d23b68524b8764fac7eae05750fb66be7d08bab98e14ed5be",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := SignRequest(test.secret, "1700000000", "nonce", "GET", "/api/services", test.body)
+			if got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"hotify/pkg/audit"
+	"hotify/pkg/config"
+	"hotify/pkg/services"
+	"log/slog"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// context keys set by the auth middleware for token requests
+const (
+	tokenNameKey = "tokenName"
+	tokenKey     = "token"
+)
+
+// authenticateToken looks up a bearer token and stores it in the context, it returns false if it is unknown
+func (s *Server) authenticateToken(c echo.Context, token string) bool {
+	name, tokenConfig := s.Manager.Token(token)
+	if tokenConfig == nil {
+		return false
+	}
+
+	c.Set(tokenNameKey, name)
+	c.Set(tokenKey, tokenConfig)
+
+	return true
+}
+
+// require is a route middleware rejecting tokens without scope for the route's service.
+// Requests signed with the secret have full access.
+func (s *Server) require(scope config.Scope) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token, _ := c.Get(tokenKey).(*config.TokenConfig)
+			if token != nil && !token.Allows(scope, c.Param("service")) {
+				return s.deny(c, actor(c), fmt.Sprintf("requires %s scope for the service", scope), true)
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// requireScope is like require, but only checks the scope. Handlers using it
+// filter their results with allowsService.
+func (s *Server) requireScope(scope config.Scope) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token, _ := c.Get(tokenKey).(*config.TokenConfig)
+			if token != nil && !token.Scope.Includes(scope) {
+				return s.deny(c, actor(c), fmt.Sprintf("requires %s scope", scope), true)
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// allowsService reports whether the request may see the service, used to filter lists
+func allowsService(c echo.Context, name string) bool {
+	token, _ := c.Get(tokenKey).(*config.TokenConfig)
+	return token == nil || token.Allows(config.ScopeRead, name)
+}
+
+type CreateTokenRequest struct {
+	Name     string       `json:"name"`
+	Scope    config.Scope `json:"scope"`
+	Services []string     `json:"services"`
+}
+
+type CreateTokenResponse struct {
+	// The token, only returned once
+	Token string `json:"token"`
+}
+
+func (s *Server) GetTokens(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.Manager.Tokens())
+}
+
+func (s *Server) CreateToken(c echo.Context) error {
+	var req CreateTokenRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, nil)
+	}
+
+	if req.Name == "" || !req.Scope.Valid() {
+		return c.JSON(http.StatusBadRequest, nil)
+	}
+	if _, ok := s.Manager.Tokens()[req.Name]; ok {
+		return c.JSON(http.StatusConflict, nil)
+	}
+
+	token, err := s.Manager.CreateToken(req.Name, req.Scope, req.Services)
+	s.record(c, audit.Entry{Action: "token.create", Detail: req.Name}, err)
+	if err != nil {
+		slog.Error("Failed to create token", "error", err)
+		return c.JSON(http.StatusInternalServerError, nil)
+	}
+
+	return c.JSON(http.StatusOK, CreateTokenResponse{
+		Token: token,
+	})
+}
+
+func (s *Server) RevokeToken(c echo.Context) error {
+	if _, ok := s.Manager.Tokens()[c.Param("token")]; !ok {
+		return c.JSON(http.StatusNotFound, nil)
+	}
+
+	err := s.Manager.RevokeToken(c.Param("token"))
+	s.record(c, audit.Entry{Action: "token.revoke", Detail: c.Param("token")}, err)
+	if errors.Is(err, services.ErrLastToken) {
+		return c.JSON(http.StatusConflict, nil)
+	}
+	if err != nil {
+		slog.Error("Failed to revoke token", "error", err)
+		return c.JSON(http.StatusInternalServerError, nil)
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
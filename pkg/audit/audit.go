@@ -0,0 +1,213 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type Result string
+
+const (
+	ResultSuccess Result = "success"
+	ResultFailure Result = "failure"
+	// The request was rejected, eg. because of an invalid webhook signature
+	ResultDenied Result = "denied"
+)
+
+// Entry records a single management action
+type Entry struct {
+	Time time.Time `json:"time"`
+	// Who performed the action, eg. secret, token:<name> or webhook
+	Actor string `json:"actor"`
+	// Source IP of the request
+	IP      string `json:"ip"`
+	Action  string `json:"action"`
+	Service string `json:"service"`
+	// Additional context, eg. the token name or the deployment rolled back to
+	Detail string `json:"detail,omitempty"`
+	Result Result `json:"result"`
+	// Error message if the action failed
+	Error string `json:"error,omitempty"`
+	// Commit deployed after the action, if any
+	Commit string `json:"commit,omitempty"`
+}
+
+// Filter selects entries, zero values match everything
+type Filter struct {
+	Actor   string
+	Action  string
+	Service string
+	Result  Result
+	Since   time.Time
+}
+
+func (f Filter) Match(entry Entry) bool {
+	return (f.Actor == "" || entry.Actor == f.Actor) &&
+		(f.Action == "" || entry.Action == f.Action) &&
+		(f.Service == "" || entry.Service == f.Service) &&
+		(f.Result == "" || entry.Result == f.Result) &&
+		!entry.Time.Before(f.Since)
+}
+
+const (
+	// Size after which the log is rotated, the previous log is kept in Path.1
+	DefaultMaxSize = 10 * 1024 * 1024
+	// Entries longer than this are skipped when reading
+	maxLineSize = 64 * 1024
+)
+
+// Log is an append-only audit trail stored as JSON lines. Once it reaches MaxSize,
+// it is moved to Path.1, replacing the older entries there.
+type Log struct {
+	Path    string
+	MaxSize int64
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func NewLog(path string) *Log {
+	return &Log{
+		Path:    path,
+		MaxSize: DefaultMaxSize,
+	}
+}
+
+// open opens the log for appending, if it isn't open yet
+func (l *Log) open() error {
+	if l.file != nil {
+		return nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(l.Path), 0755)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(l.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	l.file = file
+	l.size = info.Size()
+
+	return nil
+}
+
+// rotate moves the full log to Path.1, the next entry opens a new file
+func (l *Log) rotate() error {
+	err := l.file.Close()
+	l.file = nil
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(l.Path, l.Path+".1")
+}
+
+// Record appends an entry, setting its time if it is empty. Entries aren't synced
+// to disk one by one, so records can't be used to slow down the host.
+func (l *Log) Record(entry Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	err = l.open()
+	if err != nil {
+		return err
+	}
+
+	n, err := l.file.Write(append(data, '\n'))
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	if l.size >= l.MaxSize {
+		return l.rotate()
+	}
+
+	return nil
+}
+
+// Query returns up to limit of the newest entries matching filter in chronological order.
+// A limit of 0 returns all matches.
+func (l *Log) Query(filter Filter, limit int) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := []Entry{}
+	for _, path := range []string{l.Path + ".1", l.Path} {
+		err := readEntries(path, func(entry Entry) {
+			if filter.Match(entry) {
+				entries = append(entries, entry)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+
+	return entries, nil
+}
+
+// readEntries calls handler for every entry in the file at path, a missing file has no
+// entries. Lines that are too long or can't be parsed are skipped.
+func readEntries(path string, handler func(Entry)) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, maxLineSize)
+	for {
+		line, err := reader.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			// skip the rest of the line, it is too long to be an entry
+			for errors.Is(err, bufio.ErrBufferFull) {
+				_, err = reader.ReadSlice('\n')
+			}
+			line = nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		var entry Entry
+		if len(line) > 0 && json.Unmarshal(line, &entry) == nil {
+			handler(entry)
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
@@ -5,50 +5,119 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"hash/fnv"
 	"io"
+	"log/slog"
 	"net/http"
 	"strings"
+	"syscall"
+	"time"
 )
 
 type Upstream struct {
 	Dial string `json:"dial"`
 }
 
+type SelectionPolicy struct {
+	Policy string `json:"policy"`
+}
+
+type LoadBalancing struct {
+	SelectionPolicy *SelectionPolicy `json:"selection_policy,omitempty"`
+}
+
+// PassiveHealthChecks mark an upstream as down for FailDuration after MaxFails failed requests
+type PassiveHealthChecks struct {
+	// Duration string like "10s"
+	FailDuration string `json:"fail_duration,omitempty"`
+	MaxFails     int    `json:"max_fails,omitempty"`
+}
+
+type HealthChecks struct {
+	Passive *PassiveHealthChecks `json:"passive,omitempty"`
+}
+
 type Handle struct {
 	Handler   string     `json:"handler"`
-	Upstreams []Upstream `json:"upstreams"`
+	Upstreams []Upstream `json:"upstreams,omitempty"`
+	// Load balancing and health checks of reverse_proxy handlers
+	LoadBalancing *LoadBalancing `json:"load_balancing,omitempty"`
+	HealthChecks  *HealthChecks  `json:"health_checks,omitempty"`
+	// Path prefix removed by rewrite handlers
+	StripPathPrefix string `json:"strip_path_prefix,omitempty"`
+	// Routes of subroute handlers
+	Routes []Route `json:"routes,omitempty"`
 }
 
 type Match struct {
-	Host []string `json:"host"`
+	Host []string `json:"host,omitempty"`
+	Path []string `json:"path,omitempty"`
 }
 
 type Route struct {
-	ID     string   `json:"@id"`
+	ID     string   `json:"@id,omitempty"`
 	Handle []Handle `json:"handle"`
-	Match  []Match  `json:"match"`
-}
-
-func NewProxy(id string, match string, upstream string) Route {
-	return Route{
-		ID: id,
-		Handle: []Handle{
-			{
-				Handler: "reverse_proxy",
-				Upstreams: []Upstream{
-					{
-						Dial: upstream,
-					},
-				},
-			},
-		},
-		Match: []Match{
-			{
-				Host: []string{match},
-			},
-		},
+	Match  []Match  `json:"match,omitempty"`
+}
+
+// ReverseProxy returns the reverse_proxy handler of the route, nil if it has none
+func (r *Route) ReverseProxy() *Handle {
+	for i := range r.Handle {
+		if r.Handle[i].Handler == "reverse_proxy" {
+			return &r.Handle[i]
+		}
+	}
+
+	return nil
+}
+
+// PathMatchers returns the path matchers for a path prefix, the prefix itself and everything below
+func PathMatchers(prefix string) []string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return []string{"/*"}
+	}
+
+	return []string{prefix, prefix + "/*"}
+}
+
+// NewProxy returns a route forwarding requests for the hosts and path prefixes to the upstreams.
+// With stripPrefix, a subroute removes the matched prefix before the request is forwarded.
+func NewProxy(id string, hosts []string, paths []string, stripPrefix bool, upstreams []string) Route {
+	dials := make([]Upstream, len(upstreams))
+	for i, upstream := range upstreams {
+		dials[i] = Upstream{Dial: upstream}
+	}
+
+	match := Match{Host: hosts}
+	for _, path := range paths {
+		match.Path = append(match.Path, PathMatchers(path)...)
+	}
+
+	var handles []Handle
+	if stripPrefix {
+		var strip []Route
+		for _, path := range paths {
+			strip = append(strip, Route{
+				Match:  []Match{{Path: PathMatchers(path)}},
+				Handle: []Handle{{Handler: "rewrite", StripPathPrefix: strings.TrimSuffix(path, "/")}},
+			})
+		}
+		handles = append(handles, Handle{Handler: "subroute", Routes: strip})
+	}
+	handles = append(handles, Handle{
+		Handler:   "reverse_proxy",
+		Upstreams: dials,
+	})
+
+	route := Route{
+		ID:     id,
+		Handle: handles,
 	}
+	if len(match.Host) > 0 || len(match.Path) > 0 {
+		route.Match = []Match{match}
+	}
+
+	return route
 }
 
 type Server struct {
@@ -69,6 +138,67 @@ func NewClient(serverName string, address string) *Client {
 	}
 }
 
+const (
+	// Number of attempts for requests while Caddy refuses connections, eg. during its startup
+	RetryAttempts = 5
+	// Delay before the first retry, doubled for every further attempt
+	RetryDelay = 200 * time.Millisecond
+)
+
+// Request sends a request to the admin API and returns the response body.
+// Refused connections are retried with backoff, rejected requests return an *Error.
+func (c *Client) Request(method string, path string, object any) ([]byte, error) {
+	var payload []byte
+	if object != nil {
+		var err error
+		payload, err = json.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	delay := RetryDelay
+	for attempt := 1; ; attempt++ {
+		body, err := c.request(method, path, payload)
+		if err == nil || !errors.Is(err, syscall.ECONNREFUSED) {
+			return body, err
+		}
+		if attempt == RetryAttempts {
+			return nil, fmt.Errorf("%w: %v", ErrUnreachable, err)
+		}
+
+		slog.Warn("Caddy refused connection, retrying", "path", path, "attempt", attempt, "delay", delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (c *Client) request(method string, path string, payload []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/%s", c.Address, path), bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, newError(method, path, resp.StatusCode, body)
+	}
+
+	return body, nil
+}
+
 var BaseConfig = `{
 	"apps": {
 		"http": {
@@ -78,34 +208,23 @@ var BaseConfig = `{
 }`
 
 func (c *Client) LoadBaseConfig() error {
-	resp, err := http.Get(fmt.Sprintf("%s/config/apps/http/servers", c.Address))
-	if err != nil {
-		return err
+	_, err := c.Request("GET", "config/apps/http/servers", nil)
+	if IsNotFound(err) {
+		_, err = c.Request("POST", "config/", json.RawMessage(BaseConfig))
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		resp, err = http.Post(fmt.Sprintf("%s/config/", c.Address), "application/json", bytes.NewBuffer([]byte(BaseConfig)))
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-	}
-
-	return nil
+	return err
 }
 
 func (c *Client) LoadServer() error {
 	path := fmt.Sprintf("config/apps/http/servers/%s", c.ServerName)
 
-	resp, err := http.Get(fmt.Sprintf("%s/%s", c.Address, path))
-	if err != nil {
+	body, err := c.Request("GET", path, nil)
+	if err != nil && !IsNotFound(err) {
 		return err
 	}
-	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
 
-	if strings.HasPrefix(string(body), "null") || resp.StatusCode != http.StatusOK {
+	if err != nil || strings.HasPrefix(string(body), "null") {
 		server := Server{
 			Listen: []string{":443"},
 			Routes: []Route{},
@@ -140,69 +259,73 @@ func (c *Client) Init() error {
 	return nil
 }
 
-func (c *Client) ObjectExists(path string) bool {
-	resp, err := http.Get(fmt.Sprintf("%s/%s", c.Address, path))
+// ObjectExists reports whether the config contains an object at path
+func (c *Client) ObjectExists(path string) (bool, error) {
+	body, err := c.Request("GET", path, nil)
+	if IsNotFound(err) {
+		return false, nil
+	}
 	if err != nil {
-		return false
+		return false, err
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
 
-	return string(body) != "null\n" && resp.StatusCode == http.StatusOK
+	return strings.TrimSpace(string(body)) != "null", nil
 }
 
 func (c *Client) DeleteObject(path string) error {
-	req, _ := http.NewRequest("DELETE", fmt.Sprintf("%s/%s", c.Address, path), nil)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	return nil
+	_, err := c.Request("DELETE", path, nil)
+	return err
 }
 
 func (c *Client) SetObject(method string, path string, object any) error {
-	marshaled, err := json.Marshal(object)
-	if err != nil {
-		return err
-	}
+	_, err := c.Request(method, path, object)
+	return err
+}
 
-	req, err := http.NewRequest(method, fmt.Sprintf("%s/%s", c.Address, path), bytes.NewBuffer(marshaled))
+func (c *Client) AddRoute(route Route) error {
+	exists, err := c.ObjectExists(fmt.Sprintf("id/%s", route.ID))
 	if err != nil {
 		return err
 	}
+	if exists {
+		return errors.New("route already exists")
+	}
 
-	req.Header.Set("Content-Type", "application/json")
+	path := fmt.Sprintf("config/apps/http/servers/%s/routes", c.ServerName)
 
-	resp, err := http.DefaultClient.Do(req)
+	// Caddy uses the first matching route, so routes for paths are
+	// inserted at the front to take precedence over routes for whole hosts
+	if len(route.Match) > 0 && len(route.Match[0].Path) > 0 {
+		err = c.SetObject("PUT", path+"/0", route)
+		if err != nil {
+			return err
+		}
+
+		c.Server.Routes = append([]Route{route}, c.Server.Routes...)
+		return nil
+	}
+
+	err = c.SetObject("POST", path, route)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
+	c.Server.Routes = append(c.Server.Routes, route)
 	return nil
 }
 
-func (c *Client) AddRoute(route Route) error {
-	if c.ObjectExists(fmt.Sprintf("id/%s", route.ID)) {
-		return errors.New("route already exists")
+// GetRoutes returns the routes of the server
+func (c *Client) GetRoutes() ([]Route, error) {
+	body, err := c.Request("GET", fmt.Sprintf("config/apps/http/servers/%s/routes", c.ServerName), nil)
+	if err != nil {
+		return nil, err
 	}
 
-	path := fmt.Sprintf("config/apps/http/servers/%s/routes", c.ServerName)
-
-	err := c.SetObject("POST", path, route)
+	var routes []Route
+	err = json.Unmarshal(body, &routes)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	c.Server.Routes = append(c.Server.Routes, route)
-	return nil
-}
-
-func GenerateID(match string) string {
-	h := fnv.New64a()
-	h.Write([]byte(match))
-	return fmt.Sprintf("%x", h.Sum64())
+	return routes, nil
 }
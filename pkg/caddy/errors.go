@@ -0,0 +1,52 @@
+package caddy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrUnreachable is returned when the admin API can't be reached, even after retrying
+var ErrUnreachable = errors.New("caddy admin API unreachable")
+
+// Error is returned when the admin API rejects a request
+type Error struct {
+	Method     string
+	Path       string
+	StatusCode int
+	// Error message returned by Caddy
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("caddy %s /%s failed with status %d: %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err is a rejected request for an object that doesn't exist
+func IsNotFound(err error) bool {
+	var caddyErr *Error
+	return errors.As(err, &caddyErr) &&
+		(caddyErr.StatusCode == http.StatusNotFound ||
+			// Caddy returns 400 for config paths that can't be traversed
+			caddyErr.StatusCode == http.StatusBadRequest && strings.Contains(caddyErr.Message, "invalid traversal path"))
+}
+
+// newError creates an Error from the response body, Caddy sends {"error": "message"}
+func newError(method string, path string, statusCode int, body []byte) *Error {
+	var response struct {
+		Error string `json:"error"`
+	}
+	message := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &response) == nil && response.Error != "" {
+		message = response.Error
+	}
+
+	return &Error{
+		Method:     method,
+		Path:       path,
+		StatusCode: statusCode,
+		Message:    message,
+	}
+}
@@ -0,0 +1,196 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBackups is the number of config backups kept if Config.Backups is not set
+const DefaultBackups = 10
+
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+var saveMu sync.Mutex
+
+type Backup struct {
+	// File name of the backup, used to restore it
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+// BackupDir returns the directory backups of the config at path are stored in
+func BackupDir(path string) string {
+	return path + ".backups"
+}
+
+// writeConfig replaces the file at path with data, backing up the previous content first.
+// Nothing is written if the content didn't change.
+func writeConfig(path string, data []byte, keep int) error {
+	previous, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil && bytes.Equal(previous, data) {
+		return nil
+	}
+
+	if err == nil {
+		err = backup(path, previous, keep)
+		if err != nil {
+			return fmt.Errorf("failed to back up config: %s, err: %v", path, err)
+		}
+	}
+
+	return writeAtomic(path, data)
+}
+
+// writeAtomic writes data to a temporary file next to path, syncs it and renames it over path
+func writeAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	file, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// no-op once renamed
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	// the config contains secrets, keep the permissions of the existing file or restrict them
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	err = os.Chmod(file.Name(), mode)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(file.Name(), path)
+	if err != nil {
+		return err
+	}
+
+	// make the rename durable
+	dirFile, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer dirFile.Close()
+
+	return dirFile.Sync()
+}
+
+// backup stores data as a timestamped backup of the config at path and removes old backups
+func backup(path string, data []byte, keep int) error {
+	if keep <= 0 {
+		keep = DefaultBackups
+	}
+
+	dir := BackupDir(path)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	name := time.Now().Format(backupTimeFormat) + ".toml"
+	err = writeAtomic(filepath.Join(dir, name), data)
+	if err != nil {
+		return err
+	}
+
+	backups, err := ListBackups(path)
+	if err != nil {
+		return err
+	}
+	for i := keep; i < len(backups); i++ {
+		err = os.Remove(filepath.Join(dir, backups[i].Name))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ListBackups returns the backups of the config at path, newest first
+func ListBackups(path string) ([]Backup, error) {
+	entries, err := os.ReadDir(BackupDir(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return []Backup{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []Backup{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".toml")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		t, err := time.ParseInLocation(backupTimeFormat, name, time.Local)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		backups = append(backups, Backup{
+			Name: entry.Name(),
+			Time: t,
+			Size: info.Size(),
+		})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Time.After(backups[j].Time)
+	})
+
+	return backups, nil
+}
+
+// RestoreBackup replaces the config at path with a backup after validating it.
+// The replaced config is backed up as well, so a restore can be undone.
+func RestoreBackup(path string, name string, keep int) error {
+	if name != filepath.Base(name) || !strings.HasSuffix(name, ".toml") {
+		return fmt.Errorf("invalid backup name: %s", name)
+	}
+
+	backupPath := filepath.Join(BackupDir(path), name)
+	var restored Config
+	err := restored.Load(backupPath)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(backupPath)
+	if err != nil {
+		return err
+	}
+
+	saveMu.Lock()
+	defer saveMu.Unlock()
+
+	return writeConfig(path, data, keep)
+}
@@ -1,16 +1,187 @@
 package config
 
 import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pelletier/go-toml/v2"
 )
 
+// Placeholder returned by the API instead of secret environment values
+const RedactedValue = "********"
+
+// ProxyMatch selects the requests forwarded to a service
+type ProxyMatch struct {
+	// Hosts to match, eg. example.com and www.example.com, any host if empty
+	Hosts []string `json:"hosts"`
+	// Path prefixes to match, eg. /api, any path if empty
+	Paths []string `json:"paths"`
+	// Remove the matched path prefix before forwarding, /api/users becomes /users
+	StripPrefix bool `json:"stripPrefix"`
+}
+
 type ProxyConfig struct {
-	// Address to listen on
+	// Host to match, same as a single entry in Hosts
 	Match string `json:"match"`
-	// Upstream address
+	// Hosts to match, eg. example.com and www.example.com
+	Hosts []string `json:"hosts"`
+	// Path prefixes to match, eg. /api, any path if empty
+	Paths []string `json:"paths"`
+	// Remove the matched path prefix before forwarding, /api/users becomes /users
+	StripPrefix bool `json:"stripPrefix"`
+	// Upstream address of the first replica, the others use the following ports
 	Upstream string `json:"upstream"`
+	// Upstream address used by every other deployment in blue/green mode
+	AlternateUpstream string `json:"alternateUpstream"`
+	// More matchers forwarding to the same upstream, eg. another domain with a path prefix
+	Routes []ProxyMatch `json:"routes"`
+	// How requests are spread over the replicas: round_robin (default), least_conn, random, first or ip_hash
+	LoadBalancing string `json:"loadBalancing"`
+	// Passive health checks skip a replica for FailDuration after MaxFails failed requests,
+	// disabled if FailDuration is not set. MaxFails defaults to 1.
+	MaxFails     int      `json:"maxFails"`
+	FailDuration Duration `json:"failDuration"`
+}
+
+// Matches returns every matcher of the proxy, the one from Match, Hosts,
+// Paths and StripPrefix first if it is set, followed by Routes
+func (p *ProxyConfig) Matches() []ProxyMatch {
+	hosts := p.Hosts
+	if p.Match != "" {
+		hosts = append([]string{p.Match}, p.Hosts...)
+	}
+
+	var matches []ProxyMatch
+	if len(hosts) > 0 || len(p.Paths) > 0 {
+		matches = append(matches, ProxyMatch{
+			Hosts:       hosts,
+			Paths:       p.Paths,
+			StripPrefix: p.StripPrefix,
+		})
+	}
+
+	return append(matches, p.Routes...)
+}
+
+// Targets returns every host and path combination of the matcher, eg. example.com/api,
+// * stands for any host
+func (m ProxyMatch) Targets() []string {
+	hosts := m.Hosts
+	if len(hosts) == 0 {
+		hosts = []string{"*"}
+	}
+	paths := m.Paths
+	if len(paths) == 0 {
+		paths = []string{"/"}
+	}
+
+	var targets []string
+	for _, host := range hosts {
+		for _, path := range paths {
+			if path != "/" {
+				path = strings.TrimSuffix(path, "/")
+			}
+			targets = append(targets, host+path)
+		}
+	}
+
+	return targets
+}
+
+// Enabled reports whether requests are proxied to the service
+func (p *ProxyConfig) Enabled() bool {
+	return len(p.Matches()) > 0
+}
+
+const (
+	// Stop the old process, then start the new one
+	DeployRestart = "restart"
+	// Start the new process on the alternate upstream and switch the proxy once it is healthy
+	DeployBlueGreen = "bluegreen"
+	// Restart the replicas one at a time, the others keep serving
+	DeployRolling = "rolling"
+)
+
+const (
+	LoadBalancingRoundRobin = "round_robin"
+	LoadBalancingLeastConn  = "least_conn"
+	LoadBalancingRandom     = "random"
+	LoadBalancingFirst      = "first"
+	LoadBalancingIPHash     = "ip_hash"
+)
+
+// ReplicaUpstreams returns the upstreams of n replicas, the port of upstream is
+// incremented for every replica after the first, eg. localhost:8080 and localhost:8081.
+// Without a port, every replica gets upstream.
+func ReplicaUpstreams(upstream string, n int) []string {
+	upstreams := make([]string, max(n, 1))
+
+	host, port, err := net.SplitHostPort(upstream)
+	number, portErr := strconv.Atoi(port)
+	for i := range upstreams {
+		if err != nil || portErr != nil {
+			upstreams[i] = upstream
+		} else {
+			upstreams[i] = net.JoinHostPort(host, strconv.Itoa(number+i))
+		}
+	}
+
+	return upstreams
+}
+
+// Duration is a time.Duration stored as a string like "10s" in config files and JSON
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	duration, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(duration)
+	return nil
+}
+
+// Or returns the duration, or fallback if it is not set
+func (d Duration) Or(fallback time.Duration) time.Duration {
+	if d <= 0 {
+		return fallback
+	}
+
+	return time.Duration(d)
+}
+
+type HealthCheckConfig struct {
+	// Type of the check: http, tcp or exec, empty disables health checks
+	Type string `json:"type"`
+	// URL for http checks, responses with status 2xx or 3xx are healthy.
+	// {upstream} is replaced with the upstream address of the process being checked.
+	URL string `json:"url"`
+	// Address for tcp checks, eg. localhost:8080 or {upstream}
+	Address string `json:"address"`
+	// Command for exec checks, executed in the release directory, exit code 0 is healthy
+	Command string `json:"command"`
+	// Time between checks, defaults to 10s
+	Interval Duration `json:"interval"`
+	// Time after which a check fails, defaults to 5s
+	Timeout Duration `json:"timeout"`
+	// Consecutive failures before the service is unhealthy, defaults to 3
+	Retries int `json:"retries"`
+	// Time after starting in which failures are not counted
+	StartPeriod Duration `json:"startPeriod"`
+	// Restart the service when it becomes unhealthy, requires Restart and is limited by MaxRestarts
+	Restart bool `json:"restart"`
 }
 
 type ServiceConfig struct {
@@ -18,6 +189,12 @@ type ServiceConfig struct {
 	Name string `json:"name"`
 	// Git repository URL
 	Repo string `json:"repo"`
+	// Branch to deploy, defaults to the default branch of the repository
+	Branch string `json:"branch"`
+	// Tag to deploy, takes precedence over Branch
+	Tag string `json:"tag"`
+	// Commit to deploy, takes precedence over Tag and Branch
+	Commit string `json:"commit"`
 	// Command to execute to build the service, relative to the git repository
 	Exec string `json:"exec"`
 	// Command to execute to build the service, relative to the git repository
@@ -26,25 +203,161 @@ type ServiceConfig struct {
 	Restart bool `json:"restart"`
 	// Maximum number of restarts before giving up
 	MaxRestarts int `json:"maxRestarts"`
+	// Delay before the first restart, defaults to 1s
+	RestartDelay Duration `json:"restartDelay"`
+	// Upper limit for the restart delay, defaults to 1m
+	MaxRestartDelay Duration `json:"maxRestartDelay"`
+	// Factor the restart delay grows by with every consecutive restart, defaults to 2
+	RestartMultiplier float64 `json:"restartMultiplier"`
+	// Uptime after which the restart counter is reset, defaults to 10m
+	StableUptime Duration `json:"stableUptime"`
 	// Webhook secret to trigger updates
 	Secret string `json:"secret"`
 	// Proxy configuration for Caddy
 	Proxy ProxyConfig `json:"proxy"`
+	// Health check configuration
+	HealthCheck HealthCheckConfig `json:"healthCheck"`
+	// Number of copies of Exec to run, each gets its index in INSTANCE and its own PORT, defaults to 1
+	Replicas int `json:"replicas"`
+	// Deploy strategy, either restart (default), bluegreen or rolling
+	Deploy string `json:"deploy"`
+	// Time the old process keeps serving open connections in blue/green and rolling deploys, defaults to 5s
+	DrainTime Duration `json:"drainTime"`
+	// Number of releases to keep for rollbacks, defaults to 5
+	KeepReleases int `json:"keepReleases"`
+	// Services that have to be running, and healthy if they have a health check, before this one starts
+	DependsOn []string `json:"dependsOn"`
 	// Initial build, mostly for internal use, but may be used to force a new build on startup
 	InitialBuild bool `json:"initialBuild"`
+	// Environment variables for the build and exec commands, override values from env files
+	Env map[string]string `json:"env"`
+	// Dotenv files loaded in order, relative to the git repository
+	EnvFile []string `json:"envFile"`
+	// Names of environment variables whose values are hidden in API responses
+	SecretEnv []string `json:"secretEnv"`
+}
+
+// Redacted returns a copy of the service config with the webhook secret and secret environment values hidden
+func (s *ServiceConfig) Redacted() *ServiceConfig {
+	redacted := *s
+	redacted.Secret = redact(s.Secret)
+	redacted.Env = make(map[string]string, len(s.Env))
+	for key, value := range s.Env {
+		if slices.Contains(s.SecretEnv, key) {
+			value = RedactedValue
+		}
+		redacted.Env[key] = value
+	}
+
+	return &redacted
+}
+
+// redact returns RedactedValue for a secret that is set, unset secrets stay empty
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+
+	return RedactedValue
+}
+
+// ReplicaCount returns the number of processes the service runs
+func (s *ServiceConfig) ReplicaCount() int {
+	return max(s.Replicas, 1)
+}
+
+const (
+	// Configure routes through the Caddy admin API
+	ProxyCaddy = "caddy"
+	// Serve routes with the reverse proxy built into hotify, without TLS
+	ProxyBuiltin = "builtin"
+	// Write nginx site files and reload nginx
+	ProxyNginx = "nginx"
+)
+
+type ProxyBackendConfig struct {
+	// Reverse proxy the service routes are configured in: caddy, builtin or nginx, defaults to caddy
+	Type string `json:"type"`
+	// Address of the Caddy admin API, defaults to http://localhost:2019
+	CaddyAddress string `json:"caddyAddress"`
+	// Caddy server the routes are added to, defaults to srv0
+	CaddyServer string `json:"caddyServer"`
+	// Address the built-in proxy listens on, defaults to :80,
+	// or the listen directive of nginx site files, defaults to 80
+	Listen string `json:"listen"`
+	// Directory nginx site files are written to, required for nginx
+	NginxDir string `json:"nginxDir"`
+	// Command run after nginx site files changed, defaults to nginx -s reload
+	NginxReload string `json:"nginxReload"`
+	// Time between checks that the proxy routes match the services, defaults to 1m
+	ReconcileInterval Duration `json:"reconcileInterval"`
+}
+
+type LogsConfig struct {
+	// Size in megabytes after which log files are rotated, defaults to 10
+	MaxSize int `json:"maxSize"`
+	// Time after which log files are rotated, defaults to 24h
+	RotateInterval Duration `json:"rotateInterval"`
+	// Time after which rotated log files are deleted, defaults to 168h
+	MaxAge Duration `json:"maxAge"`
+	// Maximum number of rotated log files per service, defaults to 10
+	MaxFiles int `json:"maxFiles"`
+	// Number of log lines kept in memory per service, defaults to 1000
+	BufferLines int `json:"bufferLines"`
 }
 
 type Config struct {
 	// Path to the config file if loaded
 	LoadPath string `json:"-"`
+	// hash of the data last written by Save
+	saved [sha256.Size]byte
 	// Services to manage
 	Services map[string]*ServiceConfig `json:"services"`
 	// Address for management API and interface
 	Address string `json:"address"`
 	// Path to the services folder, where the services are cloned and built
 	ServicesPath string `json:"servicesPath"`
-	// Secret to verify API requests
+	// Secret to verify API requests, grants full access
 	Secret string `json:"secret"`
+	// Scoped API tokens by name
+	Tokens map[string]*TokenConfig `json:"tokens"`
+	// Log file rotation and retention
+	Logs LogsConfig `json:"logs"`
+	// Path to the audit log, defaults to audit.log in the services folder
+	AuditPath string `json:"auditPath"`
+	// Number of config backups kept, defaults to 10
+	Backups int `json:"backups"`
+	// Number of services initialized concurrently at startup, defaults to 4
+	InitWorkers int `json:"initWorkers"`
+	// Reverse proxy backend, changes require a restart
+	Proxy ProxyBackendConfig `json:"proxy"`
+}
+
+// Redacted returns a copy of the config with secrets, secret environment values and token hashes hidden
+func (c *Config) Redacted() *Config {
+	redacted := &Config{
+		LoadPath:     c.LoadPath,
+		Services:     make(map[string]*ServiceConfig, len(c.Services)),
+		Address:      c.Address,
+		ServicesPath: c.ServicesPath,
+		Secret:       redact(c.Secret),
+		Tokens:       make(map[string]*TokenConfig, len(c.Tokens)),
+		Logs:         c.Logs,
+		AuditPath:    c.AuditPath,
+		Backups:      c.Backups,
+		InitWorkers:  c.InitWorkers,
+		Proxy:        c.Proxy,
+	}
+	for key, service := range c.Services {
+		redacted.Services[key] = service.Redacted()
+	}
+	for name, token := range c.Tokens {
+		redactedToken := *token
+		redactedToken.Hash = RedactedValue
+		redacted.Tokens[name] = &redactedToken
+	}
+
+	return redacted
 }
 
 func (c *Config) Load(path string) error {
@@ -57,6 +370,15 @@ func (c *Config) Load(path string) error {
 	err = toml.NewDecoder(file).Decode(c)
 	c.LoadPath = path
 
+	var decodeErr *toml.DecodeError
+	if errors.As(err, &decodeErr) {
+		row, column := decodeErr.Position()
+		return fmt.Errorf("%s:%d:%d: %s", path, row, column, decodeErr.Error())
+	}
+	if err != nil {
+		return err
+	}
+
 	for key, service := range c.Services {
 		if service.Name == "" {
 			service.Name = key
@@ -64,27 +386,40 @@ func (c *Config) Load(path string) error {
 		}
 	}
 
-	return err
+	return c.Validate()
 }
 
+// Save writes the config atomically: it is encoded to a temporary file that is synced and
+// renamed over path, so a crash never leaves a partial config behind. The previous
+// version is kept as a backup. Saves are serialized.
 func (c *Config) Save(path string) error {
-	if _, err := os.Stat(path); err == nil {
-		err := os.Remove(path)
-		if err != nil {
-			return err
-		}
-	}
+	saveMu.Lock()
+	defer saveMu.Unlock()
 
-	file, err := os.Create(path)
+	data, err := toml.Marshal(c)
 	if err != nil {
 		return err
 	}
 
-	encoder := toml.NewEncoder(file)
-	err = encoder.Encode(c)
+	err = writeConfig(path, data, c.Backups)
 	if err != nil {
 		return err
 	}
+	c.saved = sha256.Sum256(data)
 
 	return nil
 }
+
+// ChangedOnDisk reports whether the file at path differs from what Save wrote last,
+// so watchers can skip the changes hotify made itself
+func (c *Config) ChangedOnDisk(path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return true
+	}
+
+	saveMu.Lock()
+	defer saveMu.Unlock()
+
+	return sha256.Sum256(data) != c.saved
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// StartOrder returns the names of the services ordered so that every service comes
+// after its dependencies. Services without dependencies between them are sorted by name.
+// Unknown dependencies are ignored, cycles are returned as an error.
+func (c *Config) StartOrder() ([]string, error) {
+	names := make([]string, 0, len(c.Services))
+	for name := range c.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(names))
+	order := make([]string, 0, len(names))
+	var path []string
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			// the cycle is the part of the path starting at name
+			for i, other := range path {
+				if other == name {
+					cycle := append(append([]string{}, path[i:]...), name)
+					return fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+				}
+			}
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+
+		dependencies := append([]string{}, c.Services[name].DependsOn...)
+		sort.Strings(dependencies)
+		for _, dependency := range dependencies {
+			if _, ok := c.Services[dependency]; !ok {
+				continue
+			}
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[name] = visited
+		order = append(order, name)
+
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+// Dependents returns the names of the services that depend on the service, sorted by name
+func (c *Config) Dependents(name string) []string {
+	var dependents []string
+	for key, service := range c.Services {
+		for _, dependency := range service.DependsOn {
+			if dependency == name {
+				dependents = append(dependents, key)
+				break
+			}
+		}
+	}
+	sort.Strings(dependents)
+
+	return dependents
+}
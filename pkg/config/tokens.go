@@ -0,0 +1,80 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"time"
+)
+
+type Scope string
+
+const (
+	// View services, logs and deployments
+	ScopeRead Scope = "read"
+	// Read, plus start, stop, restart, update and roll back services
+	ScopeDeploy Scope = "deploy"
+	// Full access, including creating and deleting services and managing tokens
+	ScopeAdmin Scope = "admin"
+)
+
+var scopeLevels = map[Scope]int{
+	ScopeRead:   1,
+	ScopeDeploy: 2,
+	ScopeAdmin:  3,
+}
+
+func (s Scope) Valid() bool {
+	return scopeLevels[s] > 0
+}
+
+// Includes reports whether the scope grants everything other grants
+func (s Scope) Includes(other Scope) bool {
+	return scopeLevels[s] >= scopeLevels[other]
+}
+
+// Prefix of generated API tokens
+const TokenPrefix = "hotify_"
+
+type TokenConfig struct {
+	// SHA-256 hash of the token, the token itself is only shown when it is created
+	Hash string `json:"hash"`
+	// Access level, either read, deploy or admin
+	Scope Scope `json:"scope"`
+	// Services the token may access, all services if empty
+	Services []string `json:"services"`
+	// Time the token was created
+	Created time.Time `json:"created"`
+}
+
+// Allows reports whether the token grants scope for the service. An empty service
+// name stands for actions that aren't tied to a single service, these
+// require a token without service restrictions.
+func (t *TokenConfig) Allows(scope Scope, service string) bool {
+	if !t.Scope.Includes(scope) {
+		return false
+	}
+	if len(t.Services) == 0 {
+		return true
+	}
+
+	return service != "" && slices.Contains(t.Services, service)
+}
+
+// NewToken generates a random API token and returns it with its hash
+func NewToken() (token string, hash string, err error) {
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	token = TokenPrefix + hex.EncodeToString(secret)
+	return token, HashToken(token), nil
+}
+
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
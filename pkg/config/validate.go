@@ -0,0 +1,456 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValidationError is a problem with a single config value
+type ValidationError struct {
+	// TOML key path of the value, eg. Services.web.Proxy.Upstream
+	Key     string `json:"key"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Key, e.Message)
+}
+
+// ValidationErrors lists every problem found in a config
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+func (e *ValidationErrors) add(key string, format string, args ...any) {
+	*e = append(*e, ValidationError{
+		Key:     key,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// err returns nil if there are no errors, so a nil ValidationErrors isn't returned as a non-nil error
+func (e ValidationErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+var (
+	// service names become directory names, so only allow safe characters
+	serviceNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	envNamePattern     = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// Validate checks the whole config and returns ValidationErrors listing every problem
+func (c *Config) Validate() error {
+	var errs ValidationErrors
+
+	// without a secret, the API is only accessible with tokens
+	if c.Secret == "" && len(c.Tokens) == 0 {
+		errs.add("Secret", "is required if no API tokens are configured")
+	}
+
+	if c.Logs.MaxSize < 0 {
+		errs.add("Logs.MaxSize", "must not be negative")
+	}
+	if c.Logs.RotateInterval < 0 {
+		errs.add("Logs.RotateInterval", "must not be negative")
+	}
+	if c.Logs.MaxAge < 0 {
+		errs.add("Logs.MaxAge", "must not be negative")
+	}
+	if c.Logs.MaxFiles < 0 {
+		errs.add("Logs.MaxFiles", "must not be negative")
+	}
+	if c.Logs.BufferLines < 0 {
+		errs.add("Logs.BufferLines", "must not be negative")
+	}
+	if c.InitWorkers < 0 {
+		errs.add("InitWorkers", "must not be negative")
+	}
+
+	switch c.Proxy.Type {
+	case "", ProxyCaddy, ProxyBuiltin:
+	case ProxyNginx:
+		if c.Proxy.NginxDir == "" {
+			errs.add("Proxy.NginxDir", "is required for nginx")
+		}
+	default:
+		errs.add("Proxy.Type", "must be %s, %s or %s, got %q", ProxyCaddy, ProxyBuiltin, ProxyNginx, c.Proxy.Type)
+	}
+	if c.Proxy.ReconcileInterval < 0 {
+		errs.add("Proxy.ReconcileInterval", "must not be negative")
+	}
+	if c.Proxy.CaddyAddress != "" {
+		if u, err := url.Parse(c.Proxy.CaddyAddress); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs.add("Proxy.CaddyAddress", "must be an http or https URL, eg. http://localhost:2019")
+		}
+	}
+
+	for name, token := range c.Tokens {
+		if !token.Scope.Valid() {
+			errs.add(fmt.Sprintf("Tokens.%s.Scope", name), "must be read, deploy or admin, got %q", token.Scope)
+		}
+	}
+
+	// sorted, so duplicates are always reported on the same service
+	keys := make([]string, 0, len(c.Services))
+	for key := range c.Services {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	targets := map[string]string{}
+	for _, key := range keys {
+		service := c.Services[key]
+		prefix := "Services." + key
+
+		if service.Name != key {
+			errs.add(prefix+".Name", "must match the key %q, got %q", key, service.Name)
+		}
+		errs = append(errs, service.validate(prefix)...)
+		errs = append(errs, service.Proxy.validateTargets(prefix, key, targets)...)
+	}
+
+	for _, key := range keys {
+		errs = append(errs, c.validateDependencies(c.Services[key], "Services."+key)...)
+	}
+	if _, err := c.StartOrder(); err != nil {
+		errs.add("Services", "%v", err)
+	}
+
+	return errs.err()
+}
+
+// validateDependencies checks that the dependencies of a service exist
+func (c *Config) validateDependencies(service *ServiceConfig, prefix string) ValidationErrors {
+	var errs ValidationErrors
+	for i, dependency := range service.DependsOn {
+		key := fmt.Sprintf("%s.DependsOn[%d]", prefix, i)
+		switch {
+		case dependency == service.Name:
+			errs.add(key, "a service can't depend on itself")
+		case c.Services[dependency] == nil:
+			errs.add(key, "unknown service %q", dependency)
+		}
+	}
+
+	return errs
+}
+
+// ValidateService checks a service that is added to or replaced in the config,
+// including conflicts with the other services
+func (c *Config) ValidateService(service *ServiceConfig) error {
+	prefix := "Services." + service.Name
+	errs := service.validate(prefix)
+
+	targets := map[string]string{}
+	for key, other := range c.Services {
+		if key != service.Name {
+			other.Proxy.validateTargets("", key, targets)
+		}
+	}
+	errs = append(errs, service.Proxy.validateTargets(prefix, service.Name, targets)...)
+
+	errs = append(errs, c.validateDependencies(service, prefix)...)
+
+	// check for cycles with the service in place
+	candidate := &Config{Services: maps.Clone(c.Services)}
+	if candidate.Services == nil {
+		candidate.Services = map[string]*ServiceConfig{}
+	}
+	candidate.Services[service.Name] = service
+	if _, err := candidate.StartOrder(); err != nil {
+		errs.add(prefix+".DependsOn", "%v", err)
+	}
+
+	return errs.err()
+}
+
+// validate checks the values of a single service, prefix is its TOML key path
+func (s *ServiceConfig) validate(prefix string) ValidationErrors {
+	var errs ValidationErrors
+
+	switch {
+	case s.Name == "":
+		errs.add(prefix+".Name", "is required")
+	case s.Name == "." || s.Name == ".." || !serviceNamePattern.MatchString(s.Name):
+		errs.add(prefix+".Name", "may only contain letters, digits, '.', '_' and '-', got %q", s.Name)
+	}
+	if s.Repo == "" {
+		errs.add(prefix+".Repo", "is required")
+	}
+	if strings.TrimSpace(s.Exec) == "" {
+		errs.add(prefix+".Exec", "is required")
+	}
+
+	if s.MaxRestarts < 0 {
+		errs.add(prefix+".MaxRestarts", "must not be negative")
+	}
+	if s.RestartDelay < 0 {
+		errs.add(prefix+".RestartDelay", "must not be negative")
+	}
+	if s.MaxRestartDelay < 0 {
+		errs.add(prefix+".MaxRestartDelay", "must not be negative")
+	}
+	if s.RestartDelay > 0 && s.MaxRestartDelay > 0 && s.MaxRestartDelay < s.RestartDelay {
+		errs.add(prefix+".MaxRestartDelay", "must not be less than RestartDelay")
+	}
+	if s.RestartMultiplier != 0 && s.RestartMultiplier < 1 {
+		errs.add(prefix+".RestartMultiplier", "must be at least 1")
+	}
+	if s.StableUptime < 0 {
+		errs.add(prefix+".StableUptime", "must not be negative")
+	}
+	if s.DrainTime < 0 {
+		errs.add(prefix+".DrainTime", "must not be negative")
+	}
+	if s.KeepReleases < 0 {
+		errs.add(prefix+".KeepReleases", "must not be negative")
+	}
+
+	if s.Proxy.Enabled() {
+		if s.Proxy.Upstream == "" {
+			errs.add(prefix+".Proxy.Upstream", "is required when a host or path is set")
+		}
+	} else if s.Proxy.Upstream != "" || s.Proxy.AlternateUpstream != "" {
+		errs.add(prefix+".Proxy.Hosts", "a host or path is required when an upstream is set")
+	}
+	if s.Proxy.Match != "" {
+		errs = append(errs, validateHost(prefix+".Proxy.Match", s.Proxy.Match)...)
+	}
+	errs = append(errs, validateMatch(prefix+".Proxy", ProxyMatch{
+		Hosts:       s.Proxy.Hosts,
+		Paths:       s.Proxy.Paths,
+		StripPrefix: s.Proxy.StripPrefix,
+	})...)
+	for i, match := range s.Proxy.Routes {
+		key := fmt.Sprintf("%s.Proxy.Routes[%d]", prefix, i)
+		if len(match.Hosts) == 0 && len(match.Paths) == 0 {
+			errs.add(key, "a host or path is required")
+		}
+		errs = append(errs, validateMatch(key, match)...)
+	}
+	if s.Proxy.Upstream != "" {
+		if err := validateAddress(s.Proxy.Upstream); err != nil {
+			errs.add(prefix+".Proxy.Upstream", "%v", err)
+		}
+	}
+	if s.Proxy.AlternateUpstream != "" {
+		if err := validateAddress(s.Proxy.AlternateUpstream); err != nil {
+			errs.add(prefix+".Proxy.AlternateUpstream", "%v", err)
+		} else if s.Proxy.AlternateUpstream == s.Proxy.Upstream {
+			errs.add(prefix+".Proxy.AlternateUpstream", "must differ from Proxy.Upstream")
+		}
+	}
+
+	if s.Replicas < 0 {
+		errs.add(prefix+".Replicas", "must not be negative")
+	}
+	if s.Replicas > 1 && s.Proxy.Upstream != "" {
+		errs = append(errs, s.Proxy.validateReplicas(prefix+".Proxy", s.Replicas)...)
+	}
+	switch s.Proxy.LoadBalancing {
+	case "", LoadBalancingRoundRobin, LoadBalancingLeastConn, LoadBalancingRandom, LoadBalancingFirst, LoadBalancingIPHash:
+	default:
+		errs.add(prefix+".Proxy.LoadBalancing", "must be %s, %s, %s, %s or %s, got %q",
+			LoadBalancingRoundRobin, LoadBalancingLeastConn, LoadBalancingRandom, LoadBalancingFirst, LoadBalancingIPHash,
+			s.Proxy.LoadBalancing)
+	}
+	if s.Proxy.MaxFails < 0 {
+		errs.add(prefix+".Proxy.MaxFails", "must not be negative")
+	}
+	if s.Proxy.FailDuration < 0 {
+		errs.add(prefix+".Proxy.FailDuration", "must not be negative")
+	}
+
+	switch s.Deploy {
+	case "", DeployRestart, DeployRolling:
+	case DeployBlueGreen:
+		if s.Proxy.AlternateUpstream == "" {
+			errs.add(prefix+".Proxy.AlternateUpstream", "is required for blue/green deploys")
+		}
+		if s.HealthCheck.Type == "" {
+			errs.add(prefix+".HealthCheck.Type", "is required for blue/green deploys")
+		}
+	default:
+		errs.add(prefix+".Deploy", "must be %s, %s or %s, got %q", DeployRestart, DeployBlueGreen, DeployRolling, s.Deploy)
+	}
+
+	errs = append(errs, s.HealthCheck.validate(prefix+".HealthCheck")...)
+	// an unhealthy process is killed and started again by the restart logic of the service
+	if s.HealthCheck.Restart && !s.Restart {
+		errs.add(prefix+".HealthCheck.Restart", "requires Restart to be enabled")
+	}
+
+	for key := range s.Env {
+		if !envNamePattern.MatchString(key) {
+			errs.add(fmt.Sprintf("%s.Env.%s", prefix, key), "is not a valid environment variable name")
+		}
+	}
+	for i, file := range s.EnvFile {
+		if file == "" {
+			errs.add(fmt.Sprintf("%s.EnvFile[%d]", prefix, i), "must not be empty")
+		}
+	}
+
+	return errs
+}
+
+func (h *HealthCheckConfig) validate(prefix string) ValidationErrors {
+	var errs ValidationErrors
+
+	switch h.Type {
+	case "":
+		return nil
+	case "http":
+		// the placeholder is only known when the check runs
+		target := strings.ReplaceAll(h.URL, "{upstream}", "localhost:80")
+		if h.URL == "" {
+			errs.add(prefix+".URL", "is required for http checks")
+		} else if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs.add(prefix+".URL", "must be an http or https URL, got %q", h.URL)
+		}
+	case "tcp":
+		if h.Address == "" {
+			errs.add(prefix+".Address", "is required for tcp checks")
+		} else if h.Address != "{upstream}" {
+			if err := validateAddress(h.Address); err != nil {
+				errs.add(prefix+".Address", "%v", err)
+			}
+		}
+	case "exec":
+		if strings.TrimSpace(h.Command) == "" {
+			errs.add(prefix+".Command", "is required for exec checks")
+		}
+	default:
+		errs.add(prefix+".Type", "must be http, tcp or exec, got %q", h.Type)
+	}
+
+	if h.Interval < 0 {
+		errs.add(prefix+".Interval", "must not be negative")
+	}
+	if h.Timeout < 0 {
+		errs.add(prefix+".Timeout", "must not be negative")
+	}
+	if h.Retries < 0 {
+		errs.add(prefix+".Retries", "must not be negative")
+	}
+	if h.StartPeriod < 0 {
+		errs.add(prefix+".StartPeriod", "must not be negative")
+	}
+
+	return errs
+}
+
+// validateMatch checks the hosts and paths of a proxy matcher, prefix is its TOML key path
+func validateMatch(prefix string, match ProxyMatch) ValidationErrors {
+	var errs ValidationErrors
+	for i, host := range match.Hosts {
+		errs = append(errs, validateHost(fmt.Sprintf("%s.Hosts[%d]", prefix, i), host)...)
+	}
+	for i, path := range match.Paths {
+		if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, " *?#") {
+			errs.add(fmt.Sprintf("%s.Paths[%d]", prefix, i), "must be a path prefix starting with /, got %q", path)
+		}
+	}
+	if match.StripPrefix && len(match.Paths) == 0 {
+		errs.add(prefix+".StripPrefix", "requires a path")
+	}
+
+	return errs
+}
+
+func validateHost(key string, host string) ValidationErrors {
+	var errs ValidationErrors
+	if host == "" || strings.ContainsAny(host, "/ :") {
+		errs.add(key, "must be a host name like example.com, got %q", host)
+	}
+
+	return errs
+}
+
+// validateTargets reports host and path combinations that are already used.
+// targets maps them to the service using them and is updated with the ones of the proxy.
+func (p *ProxyConfig) validateTargets(prefix string, service string, targets map[string]string) ValidationErrors {
+	var errs ValidationErrors
+	offset := len(p.Matches()) - len(p.Routes)
+	for i, match := range p.Matches() {
+		key := prefix + ".Proxy"
+		if i >= offset {
+			key = fmt.Sprintf("%s.Proxy.Routes[%d]", prefix, i-offset)
+		}
+
+		for _, target := range match.Targets() {
+			if other, ok := targets[target]; ok {
+				errs.add(key, "%s is already used by service %q", target, other)
+			}
+			targets[target] = service
+		}
+	}
+
+	return errs
+}
+
+// validateReplicas checks that the ports of all replicas are valid and the
+// replicas of the upstream and the alternate upstream don't share ports
+func (p *ProxyConfig) validateReplicas(prefix string, replicas int) ValidationErrors {
+	var errs ValidationErrors
+
+	seen := map[string]bool{}
+	upstreams := []struct{ key, address string }{
+		{"Upstream", p.Upstream},
+		{"AlternateUpstream", p.AlternateUpstream},
+	}
+	for _, upstream := range upstreams {
+		if upstream.address == "" || validateAddress(upstream.address) != nil {
+			continue
+		}
+
+		for _, address := range ReplicaUpstreams(upstream.address, replicas) {
+			if err := validateAddress(address); err != nil {
+				errs.add(prefix+"."+upstream.key, "not enough ports for %d replicas: %v", replicas, err)
+				break
+			}
+			if seen[address] {
+				errs.add(prefix+"."+upstream.key, "replicas of Upstream and AlternateUpstream share the address %s", address)
+				break
+			}
+			seen[address] = true
+		}
+	}
+
+	return errs
+}
+
+// validateAddress checks a host:port address as used for upstreams
+func validateAddress(address string) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("must be host:port, got %q", address)
+	}
+	if host == "" {
+		return fmt.Errorf("host is missing in %q", address)
+	}
+
+	number, err := strconv.Atoi(port)
+	if err != nil || number < 1 || number > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535, got %q", port)
+	}
+
+	return nil
+}
@@ -0,0 +1,348 @@
+package config
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// validConfig returns a config without problems, the test cases break one value each
+func validConfig() *Config {
+	return &Config{
+		Secret: "secret",
+		Services: map[string]*ServiceConfig{
+			"web": {
+				Name: "web",
+				Repo: "https://example.com/web.git",
+				Exec: "./web",
+				Proxy: ProxyConfig{
+					Hosts:    []string{"example.com"},
+					Upstream: "localhost:8080",
+				},
+			},
+			"db": {
+				Name: "db",
+				Repo: "https://example.com/db.git",
+				Exec: "./db",
+			},
+		},
+	}
+}
+
+// errorKeys returns the keys of the validation errors in err
+func errorKeys(t *testing.T, err error) []string {
+	t.Helper()
+
+	if err == nil {
+		return nil
+	}
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected ValidationErrors, got %T: %v", err, err)
+	}
+
+	keys := make([]string, len(errs))
+	for i, err := range errs {
+		keys[i] = err.Key
+	}
+	return keys
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		keys   []string
+	}{
+		{
+			name:   "valid",
+			change: func(c *Config) {},
+		},
+		{
+			name:   "missing secret without tokens",
+			change: func(c *Config) { c.Secret = "" },
+			keys:   []string{"Secret"},
+		},
+		{
+			name: "missing secret with tokens",
+			change: func(c *Config) {
+				c.Secret = ""
+				c.Tokens = map[string]*TokenConfig{"ci": {Scope: ScopeDeploy}}
+			},
+		},
+		{
+			name:   "invalid token scope",
+			change: func(c *Config) { c.Tokens = map[string]*TokenConfig{"ci": {Scope: "write"}} },
+			keys:   []string{"Tokens.ci.Scope"},
+		},
+		{
+			name:   "negative log size",
+			change: func(c *Config) { c.Logs.MaxSize = -1 },
+			keys:   []string{"Logs.MaxSize"},
+		},
+		{
+			name:   "unknown proxy type",
+			change: func(c *Config) { c.Proxy.Type = "traefik" },
+			keys:   []string{"Proxy.Type"},
+		},
+		{
+			name:   "nginx without directory",
+			change: func(c *Config) { c.Proxy.Type = ProxyNginx },
+			keys:   []string{"Proxy.NginxDir"},
+		},
+		{
+			name:   "caddy address without scheme",
+			change: func(c *Config) { c.Proxy.CaddyAddress = "localhost:2019" },
+			keys:   []string{"Proxy.CaddyAddress"},
+		},
+		{
+			name:   "name different from key",
+			change: func(c *Config) { c.Services["web"].Name = "api" },
+			keys:   []string{"Services.web.Name"},
+		},
+		{
+			name: "unsafe name",
+			change: func(c *Config) {
+				c.Services[".."] = c.Services["web"]
+				c.Services[".."].Name = ".."
+				delete(c.Services, "web")
+			},
+			keys: []string{"Services....Name"},
+		},
+		{
+			name: "missing repo and exec",
+			change: func(c *Config) {
+				c.Services["web"].Repo = ""
+				c.Services["web"].Exec = " "
+			},
+			keys: []string{"Services.web.Repo", "Services.web.Exec"},
+		},
+		{
+			name: "max restart delay below restart delay",
+			change: func(c *Config) {
+				c.Services["web"].RestartDelay = Duration(10)
+				c.Services["web"].MaxRestartDelay = Duration(5)
+			},
+			keys: []string{"Services.web.MaxRestartDelay"},
+		},
+		{
+			name:   "restart multiplier below 1",
+			change: func(c *Config) { c.Services["web"].RestartMultiplier = 0.5 },
+			keys:   []string{"Services.web.RestartMultiplier"},
+		},
+		{
+			name:   "proxy without upstream",
+			change: func(c *Config) { c.Services["web"].Proxy.Upstream = "" },
+			keys:   []string{"Services.web.Proxy.Upstream"},
+		},
+		{
+			name:   "upstream without host or path",
+			change: func(c *Config) { c.Services["db"].Proxy.Upstream = "localhost:5432" },
+			keys:   []string{"Services.db.Proxy.Hosts"},
+		},
+		{
+			name:   "upstream without port",
+			change: func(c *Config) { c.Services["web"].Proxy.Upstream = "localhost" },
+			keys:   []string{"Services.web.Proxy.Upstream"},
+		},
+		{
+			name:   "alternate upstream equal to upstream",
+			change: func(c *Config) { c.Services["web"].Proxy.AlternateUpstream = "localhost:8080" },
+			keys:   []string{"Services.web.Proxy.AlternateUpstream"},
+		},
+		{
+			name:   "host with scheme",
+			change: func(c *Config) { c.Services["web"].Proxy.Hosts = []string{"https://example.com"} },
+			keys:   []string{"Services.web.Proxy.Hosts[0]"},
+		},
+		{
+			name:   "path without slash",
+			change: func(c *Config) { c.Services["web"].Proxy.Paths = []string{"api"} },
+			keys:   []string{"Services.web.Proxy.Paths[0]"},
+		},
+		{
+			name:   "strip prefix without path",
+			change: func(c *Config) { c.Services["web"].Proxy.StripPrefix = true },
+			keys:   []string{"Services.web.Proxy.StripPrefix"},
+		},
+		{
+			name:   "empty route",
+			change: func(c *Config) { c.Services["web"].Proxy.Routes = []ProxyMatch{{}} },
+			keys:   []string{"Services.web.Proxy.Routes[0]"},
+		},
+		{
+			name: "host used by two services",
+			change: func(c *Config) {
+				c.Services["db"].Proxy = ProxyConfig{Hosts: []string{"example.com"}, Upstream: "localhost:5432"}
+			},
+			// services are checked in key order, so the second one reports the conflict
+			keys: []string{"Services.web.Proxy"},
+		},
+		{
+			name: "replicas without enough ports",
+			change: func(c *Config) {
+				c.Services["web"].Replicas = 3
+				c.Services["web"].Proxy.Upstream = "localhost:65534"
+			},
+			keys: []string{"Services.web.Proxy.Upstream"},
+		},
+		{
+			name: "replicas overlapping the alternate upstream",
+			change: func(c *Config) {
+				c.Services["web"].Replicas = 2
+				c.Services["web"].Proxy.AlternateUpstream = "localhost:8081"
+			},
+			keys: []string{"Services.web.Proxy.AlternateUpstream"},
+		},
+		{
+			name:   "unknown load balancing",
+			change: func(c *Config) { c.Services["web"].Proxy.LoadBalancing = "weighted" },
+			keys:   []string{"Services.web.Proxy.LoadBalancing"},
+		},
+		{
+			name:   "unknown deploy strategy",
+			change: func(c *Config) { c.Services["web"].Deploy = "canary" },
+			keys:   []string{"Services.web.Deploy"},
+		},
+		{
+			name:   "blue/green without alternate upstream and health check",
+			change: func(c *Config) { c.Services["web"].Deploy = DeployBlueGreen },
+			keys:   []string{"Services.web.Proxy.AlternateUpstream", "Services.web.HealthCheck.Type"},
+		},
+		{
+			name:   "http health check without URL",
+			change: func(c *Config) { c.Services["web"].HealthCheck = HealthCheckConfig{Type: "http"} },
+			keys:   []string{"Services.web.HealthCheck.URL"},
+		},
+		{
+			name: "http health check with upstream placeholder",
+			change: func(c *Config) {
+				c.Services["web"].HealthCheck = HealthCheckConfig{Type: "http", URL: "http://{upstream}/health"}
+			},
+		},
+		{
+			name:   "unknown health check type",
+			change: func(c *Config) { c.Services["web"].HealthCheck = HealthCheckConfig{Type: "grpc"} },
+			keys:   []string{"Services.web.HealthCheck.Type"},
+		},
+		{
+			name: "health check restart without restart",
+			change: func(c *Config) {
+				c.Services["web"].HealthCheck = HealthCheckConfig{Type: "exec", Command: "true", Restart: true}
+			},
+			keys: []string{"Services.web.HealthCheck.Restart"},
+		},
+		{
+			name:   "invalid env name",
+			change: func(c *Config) { c.Services["web"].Env = map[string]string{"1PORT": "80"} },
+			keys:   []string{"Services.web.Env.1PORT"},
+		},
+		{
+			name:   "empty env file",
+			change: func(c *Config) { c.Services["web"].EnvFile = []string{""} },
+			keys:   []string{"Services.web.EnvFile[0]"},
+		},
+		{
+			name:   "unknown dependency",
+			change: func(c *Config) { c.Services["web"].DependsOn = []string{"cache"} },
+			keys:   []string{"Services.web.DependsOn[0]"},
+		},
+		{
+			name:   "dependency on itself",
+			change: func(c *Config) { c.Services["web"].DependsOn = []string{"web"} },
+			keys:   []string{"Services.web.DependsOn[0]", "Services"},
+		},
+		{
+			name: "dependency cycle",
+			change: func(c *Config) {
+				c.Services["web"].DependsOn = []string{"db"}
+				c.Services["db"].DependsOn = []string{"web"}
+			},
+			keys: []string{"Services"},
+		},
+		{
+			name: "every problem is reported",
+			change: func(c *Config) {
+				c.Secret = ""
+				c.InitWorkers = -1
+				c.Services["db"].Repo = ""
+			},
+			keys: []string{"Secret", "InitWorkers", "Services.db.Repo"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := validConfig()
+			test.change(config)
+
+			keys := errorKeys(t, config.Validate())
+			if !slices.Equal(keys, test.keys) {
+				t.Errorf("got errors for %q, want %q", keys, test.keys)
+			}
+		})
+	}
+}
+
+func TestValidateService(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		service *ServiceConfig
+		keys    []string
+	}{
+		{
+			name:    "new service",
+			service: &ServiceConfig{Name: "api", Repo: "repo", Exec: "./api", DependsOn: []string{"db"}},
+		},
+		{
+			name: "replaced service keeps its own hosts",
+			service: &ServiceConfig{
+				Name:  "web",
+				Repo:  "repo",
+				Exec:  "./web",
+				Proxy: ProxyConfig{Hosts: []string{"example.com"}, Upstream: "localhost:9090"},
+			},
+		},
+		{
+			name: "host of another service",
+			service: &ServiceConfig{
+				Name:  "api",
+				Repo:  "repo",
+				Exec:  "./api",
+				Proxy: ProxyConfig{Hosts: []string{"example.com"}, Upstream: "localhost:9090"},
+			},
+			keys: []string{"Services.api.Proxy"},
+		},
+		{
+			name:    "unknown dependency",
+			service: &ServiceConfig{Name: "api", Repo: "repo", Exec: "./api", DependsOn: []string{"cache"}},
+			keys:    []string{"Services.api.DependsOn[0]"},
+		},
+		{
+			name:    "cycle through the replaced service",
+			change:  func(c *Config) { c.Services["web"].DependsOn = []string{"db"} },
+			service: &ServiceConfig{Name: "db", Repo: "repo", Exec: "./db", DependsOn: []string{"web"}},
+			keys:    []string{"Services.db.DependsOn"},
+		},
+		{
+			name:    "missing values",
+			service: &ServiceConfig{Name: "api"},
+			keys:    []string{"Services.api.Repo", "Services.api.Exec"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := validConfig()
+			if test.change != nil {
+				test.change(config)
+			}
+
+			keys := errorKeys(t, config.ValidateService(test.service))
+			if !slices.Equal(keys, test.keys) {
+				t.Errorf("got errors for %q, want %q", keys, test.keys)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"os"
+	"time"
+)
+
+// Watch polls the file at path and calls onChange once its modification time or size
+// changed and then stayed the same for one interval, so half-written files are skipped.
+// It returns when stop is closed.
+func Watch(path string, interval time.Duration, stop <-chan struct{}, onChange func()) {
+	stat := func() (time.Time, int64) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return info.ModTime(), info.Size()
+	}
+
+	lastTime, lastSize := stat()
+	pending := false
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		modTime, size := stat()
+		if size < 0 {
+			// missing while being replaced, check again next time
+			continue
+		}
+
+		if !modTime.Equal(lastTime) || size != lastSize {
+			lastTime, lastSize = modTime, size
+			pending = true
+			continue
+		}
+
+		if pending {
+			pending = false
+			onChange()
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package dotenv
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]string
+	}{
+		{
+			name:  "plain values",
+			input: "A=1\nB=two words\n",
+			want:  map[string]string{"A": "1", "B": "two words"},
+		},
+		{
+			name:  "blank lines and comments",
+			input: "\n# comment\n  \nA=1\n  # indented comment\n",
+			want:  map[string]string{"A": "1"},
+		},
+		{
+			name:  "export prefix",
+			input: "export A=1",
+			want:  map[string]string{"A": "1"},
+		},
+		{
+			name:  "whitespace around key and value",
+			input: "  A  =  1  ",
+			want:  map[string]string{"A": "1"},
+		},
+		{
+			name:  "empty value",
+			input: "A=",
+			want:  map[string]string{"A": ""},
+		},
+		{
+			name:  "value containing equals",
+			input: "URL=postgres://host/db?sslmode=disable",
+			want:  map[string]string{"URL": "postgres://host/db?sslmode=disable"},
+		},
+		{
+			name:  "inline comment",
+			input: "A=1 # one",
+			want:  map[string]string{"A": "1"},
+		},
+		{
+			name:  "hash without space is part of the value",
+			input: "A=color#fff",
+			want:  map[string]string{"A": "color#fff"},
+		},
+		{
+			name:  "double quoted escapes",
+			input: `A="line\nnext\ttab \"quoted\" back\\slash"`,
+			want:  map[string]string{"A": "line\nnext\ttab \"quoted\" back\\slash"},
+		},
+		{
+			name:  "double quoted keeps comments",
+			input: `A="1 # not a comment"`,
+			want:  map[string]string{"A": "1 # not a comment"},
+		},
+		{
+			name:  "single quoted is literal",
+			input: `A='raw\n "value"'`,
+			want:  map[string]string{"A": `raw\n "value"`},
+		},
+		{
+			name:  "later keys replace earlier ones",
+			input: "A=1\nA=2",
+			want:  map[string]string{"A": "2"},
+		},
+		{
+			name:  "windows line endings",
+			input: "A=1\r\nB=2\r\n",
+			want:  map[string]string{"A": "1", "B": "2"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env, err := Parse(strings.NewReader(test.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !maps.Equal(env, test.want) {
+				t.Errorf("got %q, want %q", env, test.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{
+			name:  "missing equals",
+			input: "A=1\nB",
+			err:   "line 2: missing '='",
+		},
+		{
+			name:  "empty key",
+			input: "=1",
+			err:   "line 1: empty key",
+		},
+		{
+			name:  "unterminated double quote",
+			input: `A="1`,
+			err:   "line 1: unterminated double quoted value",
+		},
+		{
+			name:  "unterminated single quote",
+			input: "A='1",
+			err:   "line 1: unterminated single quoted value",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(test.input))
+			if err == nil || err.Error() != test.err {
+				t.Errorf("got error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
@@ -4,50 +4,174 @@ import (
 	"bytes"
 	"fmt"
 	"os/exec"
+	"strings"
 )
 
-func CloneRepo(url string, dest string) error {
-	cmd := exec.Command("git", "clone", url, dest)
+// Ref selects the revision of a repository to deploy.
+// Commit takes precedence over Tag, which takes precedence over Branch.
+// If all fields are empty, the default branch of the remote is used.
+type Ref struct {
+	Branch string
+	Tag    string
+	Commit string
+}
+
+// MatchesPush reports whether a push to pushRef (eg. refs/heads/main) should update the ref.
+// Pinned commits never match, as they can't change.
+func (r Ref) MatchesPush(pushRef string, defaultBranch string) bool {
+	switch {
+	case r.Commit != "":
+		return false
+	case r.Tag != "":
+		return pushRef == "refs/tags/"+r.Tag
+	case r.Branch != "":
+		return pushRef == "refs/heads/"+r.Branch
+	case defaultBranch == "":
+		// without knowing the default branch, pushes to every branch update
+		return strings.HasPrefix(pushRef, "refs/heads/")
+	default:
+		return pushRef == "refs/heads/"+defaultBranch
+	}
+}
+
+func run(dir string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
 	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %s, err: %v", args[0], strings.TrimSpace(stderr.String()), err)
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func CloneRepo(url string, dest string, ref Ref) error {
+	args := []string{"clone"}
+	if ref.Commit == "" {
+		if ref.Tag != "" {
+			args = append(args, "--branch", ref.Tag)
+		} else if ref.Branch != "" {
+			args = append(args, "--branch", ref.Branch)
+		}
+	}
+	args = append(args, url, dest)
+
+	_, err := run("", args...)
 	if err != nil {
 		return err
 	}
 
+	if ref.Commit != "" {
+		_, err = run(dest, "checkout", "--detach", ref.Commit)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func PullRepo(dest string) error {
-	cmd := exec.Command("git", "pull")
-	cmd.Dir = dest
-	err := cmd.Run()
+// fetch fetches the ref from origin and returns the commit hash it points to
+func fetch(dest string, ref Ref) (string, error) {
+	switch {
+	case ref.Commit != "":
+		_, err := run(dest, "fetch", "origin")
+		if err != nil {
+			return "", err
+		}
+		return run(dest, "rev-parse", ref.Commit+"^{commit}")
+	case ref.Tag != "":
+		_, err := run(dest, "fetch", "--force", "origin", fmt.Sprintf("refs/tags/%s:refs/tags/%s", ref.Tag, ref.Tag))
+		if err != nil {
+			return "", err
+		}
+	case ref.Branch != "":
+		_, err := run(dest, "fetch", "origin", ref.Branch)
+		if err != nil {
+			return "", err
+		}
+	default:
+		_, err := run(dest, "fetch", "origin", "HEAD")
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return run(dest, "rev-parse", "FETCH_HEAD^{commit}")
+}
+
+func PullRepo(dest string, ref Ref) error {
+	commit, err := fetch(dest, ref)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	// a rollback detaches the working tree, checking out the branch attaches it again
+	if ref.Commit == "" && ref.Tag == "" {
+		branch := ref.Branch
+		if branch == "" {
+			branch, err = DefaultBranch(dest)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = run(dest, "checkout", "-B", branch, commit)
+		return err
+	}
+
+	_, err = run(dest, "checkout", "--detach", commit)
+	return err
 }
 
-func IsNewestCommit(dest string) (bool, error) {
-	cmd := exec.Command("git", "fetch", "origin", "main")
-	cmd.Dir = dest
-	err := cmd.Run()
+// DefaultBranch returns the name of the default branch of origin
+func DefaultBranch(dest string) (string, error) {
+	head, err := run(dest, "symbolic-ref", "--short", "refs/remotes/origin/HEAD")
+	if err != nil {
+		// origin/HEAD is only set by clone, ask the remote if it is missing
+		_, err = run(dest, "remote", "set-head", "origin", "--auto")
+		if err != nil {
+			return "", err
+		}
+		head, err = run(dest, "symbolic-ref", "--short", "refs/remotes/origin/HEAD")
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return strings.TrimPrefix(head, "origin/"), nil
+}
+
+func IsNewestCommit(dest string, ref Ref) (bool, error) {
+	commit, err := fetch(dest, ref)
 	if err != nil {
 		return false, err
 	}
 
-	var buf bytes.Buffer
-	cmd = exec.Command("git", "diff", "--name-only", "HEAD", "FETCH_HEAD")
-	cmd.Dir = dest
-	cmd.Stdout = &buf
-	cmd.Stderr = &buf
-	err = cmd.Run()
+	head, err := HeadCommit(dest)
 	if err != nil {
-		return false, fmt.Errorf("failed to check for changes: %s", buf.String())
+		return false, fmt.Errorf("failed to check for changes: %v", err)
 	}
 
-	if len(buf.Bytes()) > 0 {
-		return false, nil
+	return head == commit, nil
+}
+
+// HeadCommit returns the commit hash currently checked out
+func HeadCommit(dest string) (string, error) {
+	return run(dest, "rev-parse", "HEAD")
+}
+
+// Checkout detaches the working tree at the given commit, fetching it if necessary
+func Checkout(dest string, commit string) error {
+	_, err := fetch(dest, Ref{Commit: commit})
+	if err != nil {
+		return err
 	}
 
-	return true, nil
+	_, err = run(dest, "checkout", "--detach", commit)
+	return err
 }
@@ -0,0 +1,78 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"hotify/pkg/config"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultInterval = 10 * time.Second
+	DefaultTimeout  = 5 * time.Second
+	DefaultRetries  = 3
+)
+
+// Check runs the health check once, returning an error if it failed.
+// Exec checks run in dir with the environment env, {upstream} in http and tcp targets is replaced with upstream.
+func Check(check *config.HealthCheckConfig, dir string, env []string, upstream string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), check.Timeout.Or(DefaultTimeout))
+	defer cancel()
+
+	switch check.Type {
+	case "http":
+		return checkHTTP(ctx, strings.ReplaceAll(check.URL, "{upstream}", upstream))
+	case "tcp":
+		return checkTCP(ctx, strings.ReplaceAll(check.Address, "{upstream}", upstream))
+	case "exec":
+		return checkExec(ctx, check.Command, dir, env)
+	default:
+		return fmt.Errorf("unknown health check type: %s", check.Type)
+	}
+}
+
+func checkHTTP(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func checkTCP(ctx context.Context, address string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+func checkExec(ctx context.Context, command string, dir string, env []string) error {
+	cmd := exec.CommandContext(ctx, "bash", "-c", command)
+	cmd.Dir = dir
+	cmd.Env = env
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("command failed: %s, err: %v", strings.TrimSpace(string(out)), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,619 @@
+package logs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	currentFile     = "current.log"
+	timestampFormat = "2006-01-02T15-04-05.000"
+	// Size in bytes after which output without a newline is stored as an entry
+	maxMessageSize = 64 * 1024
+	// Size in bytes of the longest line read from a file, escaping can make entries
+	// longer than their message
+	maxLineSize = 1024 * 1024
+)
+
+type Options struct {
+	// Size in bytes after which the current file is rotated
+	MaxSize int64
+	// Time after which the current file is rotated
+	RotateInterval time.Duration
+	// Time after which rotated files are deleted
+	MaxAge time.Duration
+	// Maximum number of rotated files to keep
+	MaxFiles int
+	// Number of lines kept in memory
+	BufferLines int
+}
+
+var DefaultOptions = Options{
+	MaxSize:        10 * 1024 * 1024,
+	RotateInterval: 24 * time.Hour,
+	MaxAge:         7 * 24 * time.Hour,
+	MaxFiles:       10,
+	BufferLines:    1000,
+}
+
+// WithDefaults returns the options with unset values replaced by DefaultOptions
+func (o Options) WithDefaults() Options {
+	if o.MaxSize <= 0 {
+		o.MaxSize = DefaultOptions.MaxSize
+	}
+	if o.RotateInterval <= 0 {
+		o.RotateInterval = DefaultOptions.RotateInterval
+	}
+	if o.MaxAge <= 0 {
+		o.MaxAge = DefaultOptions.MaxAge
+	}
+	if o.MaxFiles <= 0 {
+		o.MaxFiles = DefaultOptions.MaxFiles
+	}
+	if o.BufferLines <= 0 {
+		o.BufferLines = DefaultOptions.BufferLines
+	}
+
+	return o
+}
+
+type Stream string
+
+const (
+	StreamStdout Stream = "stdout"
+	StreamStderr Stream = "stderr"
+)
+
+type Phase string
+
+const (
+	PhaseBuild Phase = "build"
+	PhaseRun   Phase = "run"
+)
+
+// Entry is a single line of output
+type Entry struct {
+	// Sequence number, increasing with every entry written since hotify started
+	Seq    uint64    `json:"seq"`
+	Time   time.Time `json:"time"`
+	Stream Stream    `json:"stream"`
+	Phase  Phase     `json:"phase"`
+	// Deployment the output belongs to, 0 if unknown
+	Deployment int    `json:"deployment"`
+	Message    string `json:"message"`
+}
+
+// Filter selects entries, zero values match everything
+type Filter struct {
+	Stream     Stream
+	Phase      Phase
+	Deployment int
+	Since      time.Time
+}
+
+func (f Filter) Match(entry Entry) bool {
+	return (f.Stream == "" || entry.Stream == f.Stream) &&
+		(f.Phase == "" || entry.Phase == f.Phase) &&
+		(f.Deployment == 0 || entry.Deployment == f.Deployment) &&
+		!entry.Time.Before(f.Since)
+}
+
+// Subscription receives every entry written after subscribing
+type Subscription struct {
+	Entries <-chan Entry
+
+	store   *Store
+	entries chan Entry
+}
+
+// Close stops the subscription, it is closed automatically if the subscriber falls behind
+func (s *Subscription) Close() {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+
+	s.store.unsubscribe(s)
+}
+
+// Store writes log lines to rotated files in a directory and keeps the newest lines in memory
+type Store struct {
+	Dir     string
+	Options Options
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+	seq    uint64
+	ring   []Entry
+	next   int
+	subs   map[*Subscription]struct{}
+}
+
+func NewStore(dir string, options Options) *Store {
+	options = options.WithDefaults()
+
+	return &Store{
+		Dir:     dir,
+		Options: options,
+		ring:    make([]Entry, 0, options.BufferLines),
+		subs:    make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe returns a subscription buffering up to buffer entries
+func (s *Store) Subscribe(buffer int) *Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make(chan Entry, buffer)
+	sub := &Subscription{
+		Entries: entries,
+		store:   s,
+		entries: entries,
+	}
+	s.subs[sub] = struct{}{}
+
+	return sub
+}
+
+func (s *Store) unsubscribe(sub *Subscription) {
+	if _, ok := s.subs[sub]; !ok {
+		return
+	}
+
+	delete(s.subs, sub)
+	close(sub.entries)
+}
+
+// Writer splits written data into entries with the same stream, phase and deployment
+type Writer struct {
+	// Prepended to every line, eg. to tell the output of replicas apart
+	Prefix string
+
+	store    *Store
+	template Entry
+
+	mu      sync.Mutex
+	partial []byte
+}
+
+func (s *Store) Writer(stream Stream, phase Phase, deployment int) *Writer {
+	return &Writer{
+		store: s,
+		template: Entry{
+			Stream:     stream,
+			Phase:      phase,
+			Deployment: deployment,
+		},
+	}
+}
+
+// Write stores every complete line of p as an entry, implements io.Writer.
+// Lines longer than maxMessageSize are split into several entries.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.partial = append(w.partial, p...)
+	for {
+		var message string
+		index := bytes.IndexByte(w.partial, '\n')
+		switch {
+		case index != -1 && index <= maxMessageSize:
+			message = strings.TrimSuffix(string(w.partial[:index]), "\r")
+			w.partial = w.partial[index+1:]
+		case len(w.partial) >= maxMessageSize:
+			message = string(w.partial[:maxMessageSize])
+			w.partial = w.partial[maxMessageSize:]
+		default:
+			return len(p), nil
+		}
+
+		err := w.store.Append(w.template, w.Prefix+message)
+		if err != nil {
+			return len(p), err
+		}
+	}
+}
+
+// Flush stores an incomplete last line
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.partial) == 0 {
+		return nil
+	}
+
+	message := w.Prefix + string(w.partial)
+	w.partial = nil
+
+	return w.store.Append(w.template, message)
+}
+
+// Append stores a single entry with the given message, the sequence number and time are set by the store
+func (s *Store) Append(entry Entry, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	entry.Seq = s.seq
+	entry.Time = time.Now()
+	entry.Message = message
+
+	if len(s.ring) < s.Options.BufferLines {
+		s.ring = append(s.ring, entry)
+	} else {
+		s.ring[s.next] = entry
+		s.next = (s.next + 1) % len(s.ring)
+	}
+
+	for sub := range s.subs {
+		select {
+		case sub.entries <- entry:
+		default:
+			// the subscriber can resume from the last sequence number it received
+			s.unsubscribe(sub)
+		}
+	}
+
+	err := s.open()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	n, err := s.file.Write(append(data, '\n'))
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	if s.size >= s.Options.MaxSize || time.Since(s.opened) >= s.Options.RotateInterval {
+		return s.rotate()
+	}
+
+	return nil
+}
+
+func (s *Store) open() error {
+	if s.file != nil {
+		return nil
+	}
+
+	err := os.MkdirAll(s.Dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.Dir, currentFile)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	s.file = file
+	s.size = info.Size()
+	s.opened = time.Now()
+	if s.size > 0 {
+		s.opened = firstTime(path, info.ModTime())
+	}
+
+	return nil
+}
+
+// firstTime returns the time of the first entry in the file at path, or fallback if it has none
+func firstTime(path string, fallback time.Time) time.Time {
+	first := fallback
+	readEntries(path, func(entry Entry) bool {
+		if !entry.Time.IsZero() {
+			first = entry.Time
+		}
+		return false
+	})
+
+	return first
+}
+
+// rotate renames the current file after the time it was started and compresses it in the background
+func (s *Store) rotate() error {
+	err := s.file.Close()
+	s.file = nil
+	if err != nil {
+		return err
+	}
+
+	// files rotated in quick succession would get the same name
+	started := s.opened
+	rotated := filepath.Join(s.Dir, started.Format(timestampFormat)+".log")
+	for exists(rotated) || exists(rotated+".gz") {
+		started = started.Add(time.Millisecond)
+		rotated = filepath.Join(s.Dir, started.Format(timestampFormat)+".log")
+	}
+	err = os.Rename(filepath.Join(s.Dir, currentFile), rotated)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		err := compress(rotated)
+		if err != nil {
+			slog.Error("Failed to compress log file", "path", rotated, "error", err)
+		}
+
+		err = s.cleanup()
+		if err != nil {
+			slog.Error("Failed to clean up log files", "dir", s.Dir, "error", err)
+		}
+	}()
+
+	return nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func compress(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(path + ".gz.tmp")
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	writer := gzip.NewWriter(out)
+	_, err = io.Copy(writer, in)
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(path+".gz.tmp", path+".gz")
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// segments returns the rotated files, newest first
+func (s *Store) segments() ([]string, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == currentFile || !(strings.HasSuffix(name, ".log") || strings.HasSuffix(name, ".log.gz")) {
+			continue
+		}
+		segments = append(segments, name)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(segments)))
+
+	return segments, nil
+}
+
+// cleanup deletes rotated files exceeding MaxFiles or older than MaxAge
+func (s *Store) cleanup() error {
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	for i, name := range segments {
+		rotated, err := time.ParseInLocation(timestampFormat, strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".log"), time.Local)
+		if err == nil && i < s.Options.MaxFiles && time.Since(rotated) < s.Options.MaxAge {
+			continue
+		}
+
+		err = os.Remove(filepath.Join(s.Dir, name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Recent returns the entries in memory matching filter with a sequence number greater than after
+func (s *Store) Recent(after uint64, filter Filter) []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := []Entry{}
+	for i := range s.ring {
+		entry := s.ring[(s.next+i)%len(s.ring)]
+		if entry.Seq > after && filter.Match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// Tail returns the newest n entries in memory matching filter
+func (s *Store) Tail(n int, filter Filter) []Entry {
+	entries := s.Recent(0, filter)
+	if n < len(entries) {
+		entries = entries[len(entries)-n:]
+	}
+
+	return entries
+}
+
+// Page returns up to limit entries matching filter from the files in chronological
+// order, skipping the newest offset matches. more reports whether older entries exist.
+func (s *Store) Page(offset int, limit int, filter Filter) (entries []Entry, more bool, err error) {
+	s.mu.Lock()
+	if s.file != nil {
+		s.file.Sync()
+	}
+	s.mu.Unlock()
+
+	segments, err := s.segments()
+	if err != nil {
+		return nil, false, err
+	}
+	files := append([]string{currentFile}, segments...)
+
+	// one entry more than the page tells whether older entries exist
+	needed := offset + limit + 1
+
+	// collect entries from the newest file backwards until the page is full,
+	// older files are not read at all
+	var collected []Entry
+	for _, name := range files {
+		fileEntries, err := newestEntries(filepath.Join(s.Dir, name), filter, needed-len(collected))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		collected = append(fileEntries, collected...)
+		if len(collected) >= needed {
+			break
+		}
+	}
+
+	more = len(collected) == needed
+	if more {
+		collected = collected[1:]
+	}
+
+	end := len(collected) - offset
+	if end <= 0 {
+		return []Entry{}, false, nil
+	}
+	start := max(end-limit, 0)
+
+	return collected[start:end], more, nil
+}
+
+// newestEntries returns the newest n entries of a file matching filter
+func newestEntries(path string, filter Filter, n int) ([]Entry, error) {
+	var entries []Entry
+	err := readEntries(path, func(entry Entry) bool {
+		if !filter.Match(entry) {
+			return true
+		}
+
+		entries = append(entries, entry)
+		if len(entries) > 2*n {
+			entries = append(entries[:0], entries[len(entries)-n:]...)
+		}
+		return true
+	})
+	if len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+
+	return entries, err
+}
+
+// readEntries calls handler with the entries of a file until it returns false.
+// Lines that aren't JSON, eg. from older versions, become entries with only a message.
+// Lines longer than maxLineSize are skipped.
+func readEntries(path string, handler func(Entry) bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	buffered := bufio.NewReaderSize(reader, maxLineSize)
+	for {
+		line, err := buffered.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			// skip the rest of the line, it is too long to be an entry
+			for errors.Is(err, bufio.ErrBufferFull) {
+				_, err = buffered.ReadSlice('\n')
+			}
+			line = nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		if len(line) > 0 {
+			var entry Entry
+			if json.Unmarshal(line, &entry) != nil {
+				entry = Entry{Message: string(line)}
+			}
+
+			if !handler(entry) {
+				return nil
+			}
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+// Close ends all subscriptions and closes the current file
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for sub := range s.subs {
+		s.unsubscribe(sub)
+	}
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,269 @@
+package proxy
+
+import (
+	"errors"
+	"hash/fnv"
+	"hotify/pkg/config"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Builtin is a plain HTTP reverse proxy running inside hotify, for hosts without Caddy or nginx.
+// It doesn't terminate TLS, put it behind a load balancer or use Caddy for HTTPS.
+type Builtin struct {
+	// Address to listen on, eg. :80
+	Address string
+
+	routes map[string]*builtinRoute
+	mu     sync.RWMutex
+}
+
+type builtinRoute struct {
+	Route
+	upstreams []*builtinUpstream
+	next      atomic.Uint64
+}
+
+// builtinUpstream forwards requests to one upstream and remembers failed requests for passive health checks
+type builtinUpstream struct {
+	proxy  *httputil.ReverseProxy
+	active atomic.Int64
+
+	mu       sync.Mutex
+	failures []time.Time
+}
+
+func NewBuiltin(address string) *Builtin {
+	return &Builtin{
+		Address: address,
+		routes:  make(map[string]*builtinRoute),
+	}
+}
+
+// Start listens on Address and serves requests in the background
+func (b *Builtin) Start() error {
+	listener, err := net.Listen("tcp", b.Address)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Starting built-in proxy", "address", b.Address)
+
+	go func() {
+		err := http.Serve(listener, b)
+		if err != nil {
+			slog.Error("Built-in proxy stopped", "error", err)
+		}
+	}()
+
+	return nil
+}
+
+// ServeHTTP forwards the request to the most specific matching route, balancing over its upstreams.
+// Of equally specific routes, the one with the lowest ID wins, so the choice doesn't change between requests.
+func (b *Builtin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+
+	b.mu.RLock()
+	var route *builtinRoute
+	prefix, best := "", -1
+	for _, candidate := range b.routes {
+		matched, score := candidate.match(host, r.URL.Path)
+		if score > best || (score == best && route != nil && candidate.ID < route.ID) {
+			route, prefix, best = candidate, matched, score
+		}
+	}
+	b.mu.RUnlock()
+
+	if route == nil || len(route.upstreams) == 0 {
+		http.Error(w, "no route for host", http.StatusNotFound)
+		return
+	}
+
+	upstream := route.pick(r)
+	if upstream == nil {
+		http.Error(w, "no healthy upstream", http.StatusBadGateway)
+		return
+	}
+
+	if route.StripPrefix && prefix != "" {
+		r.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+		r.URL.RawPath = ""
+	}
+
+	upstream.active.Add(1)
+	defer upstream.active.Add(-1)
+	upstream.proxy.ServeHTTP(w, r)
+}
+
+// pick selects a healthy upstream for the request with the load balancing policy of the route,
+// it returns nil if every upstream failed too often
+func (r *builtinRoute) pick(req *http.Request) *builtinUpstream {
+	var healthy []*builtinUpstream
+	for _, upstream := range r.upstreams {
+		if upstream.healthy(r.MaxFails, r.FailDuration) {
+			healthy = append(healthy, upstream)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	switch r.LoadBalancing {
+	case config.LoadBalancingFirst:
+		return healthy[0]
+	case config.LoadBalancingRandom:
+		return healthy[rand.IntN(len(healthy))]
+	case config.LoadBalancingLeastConn:
+		least := healthy[0]
+		for _, upstream := range healthy[1:] {
+			if upstream.active.Load() < least.active.Load() {
+				least = upstream
+			}
+		}
+		return least
+	case config.LoadBalancingIPHash:
+		ip, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			ip = req.RemoteAddr
+		}
+		hash := fnv.New32a()
+		hash.Write([]byte(ip))
+		return healthy[hash.Sum32()%uint32(len(healthy))]
+	}
+
+	return healthy[r.next.Add(1)%uint64(len(healthy))]
+}
+
+// healthy reports whether fewer than maxFails requests failed within the last failDuration
+func (u *builtinUpstream) healthy(maxFails int, failDuration time.Duration) bool {
+	if failDuration <= 0 {
+		return true
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	cutoff := time.Now().Add(-failDuration)
+	u.failures = slices.DeleteFunc(u.failures, func(failure time.Time) bool {
+		return failure.Before(cutoff)
+	})
+
+	return len(u.failures) < max(maxFails, 1)
+}
+
+func (u *builtinUpstream) fail() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.failures = append(u.failures, time.Now())
+}
+
+// match returns the matched path prefix and a score, longer prefixes score higher and hosts break ties.
+// The score is negative if the route doesn't match.
+func (r *builtinRoute) match(host string, path string) (string, int) {
+	score := 0
+	if len(r.Hosts) > 0 {
+		if !slices.Contains(r.Hosts, host) {
+			return "", -1
+		}
+		score = 1
+	}
+	if len(r.Paths) == 0 {
+		return "", score
+	}
+
+	matched, found := "", false
+	for _, prefix := range r.Paths {
+		prefix = trimPath(prefix)
+		if matchPath(path, prefix) && (!found || len(prefix) > len(matched)) {
+			matched, found = prefix, true
+		}
+	}
+	if !found {
+		return "", -1
+	}
+
+	return matched, score + 2*(len(matched)+1)
+}
+
+func newBuiltinRoute(route Route) *builtinRoute {
+	upstreams := make([]*builtinUpstream, len(route.Upstreams))
+	for i, address := range route.Upstreams {
+		upstream := &builtinUpstream{
+			proxy: httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: address}),
+		}
+		upstream.proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			slog.Warn("Proxy request failed", "upstream", address, "error", err)
+			if route.FailDuration > 0 {
+				upstream.fail()
+			}
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		upstreams[i] = upstream
+	}
+
+	return &builtinRoute{
+		Route:     route,
+		upstreams: upstreams,
+	}
+}
+
+func (b *Builtin) Add(route Route) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.routes[route.ID] = newBuiltinRoute(route)
+
+	return nil
+}
+
+func (b *Builtin) Remove(id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.routes, id)
+
+	return nil
+}
+
+func (b *Builtin) Update(route Route) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.routes[route.ID]; !ok {
+		return errors.New("route not found")
+	}
+
+	b.routes[route.ID] = newBuiltinRoute(route)
+
+	return nil
+}
+
+func (b *Builtin) Routes() ([]Route, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	routes := make([]Route, 0, len(b.routes))
+	for _, route := range b.routes {
+		routes = append(routes, route.Route)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].ID < routes[j].ID
+	})
+
+	return routes, nil
+}
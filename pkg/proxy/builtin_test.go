@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBuiltinRouteMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   Route
+		host    string
+		path    string
+		matched string
+		score   int
+	}{
+		{
+			name:  "any host and path",
+			route: Route{},
+			host:  "example.com",
+			path:  "/",
+			score: 0,
+		},
+		{
+			name:  "matching host",
+			route: Route{Hosts: []string{"a.com", "example.com"}},
+			host:  "example.com",
+			path:  "/",
+			score: 1,
+		},
+		{
+			name:  "other host",
+			route: Route{Hosts: []string{"a.com"}},
+			host:  "example.com",
+			path:  "/",
+			score: -1,
+		},
+		{
+			name:    "exact path",
+			route:   Route{Paths: []string{"/api"}},
+			host:    "example.com",
+			path:    "/api",
+			matched: "/api",
+			score:   10,
+		},
+		{
+			name:    "path below prefix",
+			route:   Route{Paths: []string{"/api/"}},
+			host:    "example.com",
+			path:    "/api/users",
+			matched: "/api",
+			score:   10,
+		},
+		{
+			name:  "prefix of a path segment",
+			route: Route{Paths: []string{"/api"}},
+			host:  "example.com",
+			path:  "/apiv2",
+			score: -1,
+		},
+		{
+			name:    "longest of several prefixes",
+			route:   Route{Paths: []string{"/api", "/api/v2"}},
+			host:    "example.com",
+			path:    "/api/v2/users",
+			matched: "/api/v2",
+			score:   16,
+		},
+		{
+			name:    "host and path",
+			route:   Route{Hosts: []string{"example.com"}, Paths: []string{"/api"}},
+			host:    "example.com",
+			path:    "/api",
+			matched: "/api",
+			score:   11,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			route := newBuiltinRoute(test.route)
+			matched, score := route.match(test.host, test.path)
+			if matched != test.matched || score != test.score {
+				t.Errorf("got %q with score %d, want %q with score %d", matched, score, test.matched, test.score)
+			}
+		})
+	}
+}
+
+// upstream starts a server answering with its name and the path it received
+func upstream(t *testing.T, name string) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", name, r.URL.Path)
+	}))
+	t.Cleanup(server.Close)
+
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestBuiltinServeHTTP(t *testing.T) {
+	routes := []Route{
+		{ID: "hotify-any-0", Upstreams: []string{upstream(t, "any")}},
+		{ID: "hotify-host-0", Hosts: []string{"example.com"}, Upstreams: []string{upstream(t, "host")}},
+		{ID: "hotify-api-0", Paths: []string{"/api"}, StripPrefix: true, Upstreams: []string{upstream(t, "api")}},
+		{ID: "hotify-host-api-0", Hosts: []string{"example.com"}, Paths: []string{"/api"}, Upstreams: []string{upstream(t, "host-api")}},
+		// equally specific, the lower ID wins
+		{ID: "hotify-b-0", Paths: []string{"/same"}, Upstreams: []string{upstream(t, "b")}},
+		{ID: "hotify-a-0", Paths: []string{"/same"}, Upstreams: []string{upstream(t, "a")}},
+		{ID: "hotify-down-0", Hosts: []string{"down.com"}},
+	}
+
+	tests := []struct {
+		name   string
+		host   string
+		path   string
+		status int
+		body   string
+	}{
+		{name: "fallback", host: "other.com", path: "/", status: http.StatusOK, body: "any /"},
+		{name: "host", host: "example.com:8080", path: "/users", status: http.StatusOK, body: "host /users"},
+		{name: "path with stripped prefix", host: "other.com", path: "/api/users", status: http.StatusOK, body: "api /users"},
+		{name: "stripped prefix becomes root", host: "other.com", path: "/api", status: http.StatusOK, body: "api /"},
+		{name: "host and path beat path", host: "example.com", path: "/api/users", status: http.StatusOK, body: "host-api /api/users"},
+		{name: "tie broken by ID", host: "other.com", path: "/same", status: http.StatusOK, body: "a /same"},
+		{name: "route without upstreams", host: "down.com", path: "/", status: http.StatusNotFound},
+	}
+
+	builtin := NewBuiltin("")
+	for _, route := range routes {
+		err := builtin.Add(route)
+		if err != nil {
+			t.Fatalf("failed to add route %s: %v", route.ID, err)
+		}
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// repeated, so a choice depending on map order would show up
+			for range 20 {
+				request := httptest.NewRequest(http.MethodGet, "http://"+test.host+test.path, nil)
+				recorder := httptest.NewRecorder()
+				builtin.ServeHTTP(recorder, request)
+
+				if recorder.Code != test.status {
+					t.Fatalf("got status %d, want %d", recorder.Code, test.status)
+				}
+				body, _ := io.ReadAll(recorder.Body)
+				if test.body != "" && string(body) != test.body {
+					t.Fatalf("got body %q, want %q", body, test.body)
+				}
+			}
+		})
+	}
+}
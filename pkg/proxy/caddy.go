@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"hotify/pkg/caddy"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Caddy configures routes through the admin API of a running Caddy server
+type Caddy struct {
+	Client *caddy.Client
+
+	// the server config is loaded on first use, so Caddy may start after hotify
+	initialized bool
+	initMu      sync.Mutex
+}
+
+func NewCaddy(client *caddy.Client) *Caddy {
+	return &Caddy{
+		Client: client,
+	}
+}
+
+// init loads the server config from Caddy, until it succeeds once
+func (c *Caddy) init() error {
+	c.initMu.Lock()
+	defer c.initMu.Unlock()
+
+	if c.initialized {
+		return nil
+	}
+
+	err := c.Client.Init()
+	if err != nil {
+		return err
+	}
+	c.initialized = true
+
+	return nil
+}
+
+func newCaddyRoute(route Route) caddy.Route {
+	caddyRoute := caddy.NewProxy(route.ID, route.Hosts, route.Paths, route.StripPrefix, route.Upstreams)
+
+	handle := caddyRoute.ReverseProxy()
+	if route.LoadBalancing != "" {
+		handle.LoadBalancing = &caddy.LoadBalancing{
+			SelectionPolicy: &caddy.SelectionPolicy{Policy: route.LoadBalancing},
+		}
+	}
+	if route.FailDuration > 0 {
+		handle.HealthChecks = &caddy.HealthChecks{
+			Passive: &caddy.PassiveHealthChecks{
+				FailDuration: route.FailDuration.String(),
+				MaxFails:     route.MaxFails,
+			},
+		}
+	}
+
+	return caddyRoute
+}
+
+func (c *Caddy) Add(route Route) error {
+	err := c.init()
+	if err != nil {
+		return err
+	}
+
+	caddyRoute := newCaddyRoute(route)
+
+	path := fmt.Sprintf("id/%s", route.ID)
+	exists, err := c.Client.ObjectExists(path)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return c.Client.SetObject("PATCH", path, caddyRoute)
+	}
+
+	return c.Client.AddRoute(caddyRoute)
+}
+
+func (c *Caddy) Remove(id string) error {
+	err := c.init()
+	if err != nil {
+		return err
+	}
+
+	err = c.Client.DeleteObject(fmt.Sprintf("id/%s", id))
+	if caddy.IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (c *Caddy) Update(route Route) error {
+	err := c.init()
+	if err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("id/%s", route.ID)
+	exists, err := c.Client.ObjectExists(path)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New("route not found")
+	}
+
+	// replacing the whole route is atomic, requests never see a partial update
+	return c.Client.SetObject("PATCH", path, newCaddyRoute(route))
+}
+
+// Routes returns the routes owned by hotify, routes configured by hand are skipped
+func (c *Caddy) Routes() ([]Route, error) {
+	err := c.init()
+	if err != nil {
+		return nil, err
+	}
+
+	caddyRoutes, err := c.Client.GetRoutes()
+	if err != nil {
+		return nil, err
+	}
+
+	routes := []Route{}
+	for _, caddyRoute := range caddyRoutes {
+		if !Owned(caddyRoute.ID) {
+			continue
+		}
+
+		route := Route{ID: caddyRoute.ID}
+		if len(caddyRoute.Match) > 0 {
+			route.Hosts = caddyRoute.Match[0].Host
+			// every prefix has a matcher for itself and one for everything below
+			for _, path := range caddyRoute.Match[0].Path {
+				if !strings.HasSuffix(path, "/*") {
+					route.Paths = append(route.Paths, path)
+				}
+			}
+		}
+		for _, handle := range caddyRoute.Handle {
+			switch handle.Handler {
+			case "subroute":
+				route.StripPrefix = true
+			case "reverse_proxy":
+				for _, upstream := range handle.Upstreams {
+					route.Upstreams = append(route.Upstreams, upstream.Dial)
+				}
+				if handle.LoadBalancing != nil && handle.LoadBalancing.SelectionPolicy != nil {
+					route.LoadBalancing = handle.LoadBalancing.SelectionPolicy.Policy
+				}
+				if handle.HealthChecks != nil && handle.HealthChecks.Passive != nil {
+					// a duration that can't be parsed becomes 0 and is replaced as drift
+					route.FailDuration, _ = time.ParseDuration(handle.HealthChecks.Passive.FailDuration)
+					route.MaxFails = handle.HealthChecks.Passive.MaxFails
+				}
+			}
+		}
+		routes = append(routes, route)
+	}
+
+	return routes, nil
+}
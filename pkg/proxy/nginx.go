@@ -0,0 +1,305 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hotify/pkg/config"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Prefix of the comment lines at the top of the generated file, followed by a route as JSON
+const nginxHeader = "# hotify route: "
+
+// Nginx writes all routes into one file in a directory included by nginx and reloads it.
+// Routes are grouped into a server block per host, routes without hosts are added to every
+// server block and to the default server of the listen address, which must not have another one.
+type Nginx struct {
+	// Directory the file is written to, eg. /etc/nginx/conf.d
+	Dir string
+	// Value of the listen directive, eg. 80
+	Listen string
+	// Command run after the file changed, eg. nginx -s reload
+	ReloadCommand string
+
+	mu sync.Mutex
+}
+
+func NewNginx(dir string, listen string, reloadCommand string) *Nginx {
+	return &Nginx{
+		Dir:           dir,
+		Listen:        listen,
+		ReloadCommand: reloadCommand,
+	}
+}
+
+func (n *Nginx) path() string {
+	return filepath.Join(n.Dir, "hotify.conf")
+}
+
+// render returns the nginx config for the routes
+func (n *Nginx) render(routes []Route) (string, error) {
+	var b strings.Builder
+
+	for _, route := range routes {
+		header, err := json.Marshal(route)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(nginxHeader + string(header) + "\n")
+	}
+
+	for _, route := range routes {
+		renderUpstream(&b, route)
+	}
+
+	var hosts []string
+	anyHost := false
+	for _, route := range routes {
+		hosts = append(hosts, route.Hosts...)
+		anyHost = anyHost || len(route.Hosts) == 0
+	}
+	sort.Strings(hosts)
+	hosts = slices.Compact(hosts)
+	if anyHost {
+		hosts = append(hosts, "_")
+	}
+
+	for _, host := range hosts {
+		// server_name _ matches nothing, requests for other hosts only reach the default server
+		listen := n.Listen
+		if host == "_" {
+			listen += " default_server"
+		}
+		fmt.Fprintf(&b, "\nserver {\n\tlisten %s;\n\tserver_name %s;\n", listen, host)
+
+		// routes for the host take precedence over routes for any host
+		written := map[string]bool{}
+		for _, specific := range []bool{true, false} {
+			for _, route := range routes {
+				if (len(route.Hosts) > 0) != specific || (specific && !slices.Contains(route.Hosts, host)) {
+					continue
+				}
+				n.renderLocations(&b, route, written)
+			}
+		}
+
+		b.WriteString("}\n")
+	}
+
+	return b.String(), nil
+}
+
+// renderUpstream writes the upstream block of a route. Without passive health checks,
+// the max_fails and fail_timeout defaults of nginx apply.
+func renderUpstream(b *strings.Builder, route Route) {
+	fmt.Fprintf(b, "\nupstream %s {\n", route.ID)
+
+	switch route.LoadBalancing {
+	case config.LoadBalancingLeastConn, config.LoadBalancingIPHash, config.LoadBalancingRandom:
+		fmt.Fprintf(b, "\t%s;\n", route.LoadBalancing)
+	}
+
+	for i, upstream := range route.Upstreams {
+		params := ""
+		if route.FailDuration > 0 {
+			seconds := int(math.Ceil(route.FailDuration.Seconds()))
+			params += fmt.Sprintf(" max_fails=%d fail_timeout=%ds", max(route.MaxFails, 1), seconds)
+		}
+		// the other upstreams only get requests while the first one is down
+		if route.LoadBalancing == config.LoadBalancingFirst && i > 0 {
+			params += " backup"
+		}
+		fmt.Fprintf(b, "\tserver %s%s;\n", upstream, params)
+	}
+
+	b.WriteString("}\n")
+}
+
+// renderLocations writes the location blocks of a route, skipping locations already in written
+func (n *Nginx) renderLocations(b *strings.Builder, route Route, written map[string]bool) {
+	location := func(match string, target string) {
+		if written[match] {
+			return
+		}
+		written[match] = true
+
+		fmt.Fprintf(b, "\n\tlocation %s {\n", match)
+		fmt.Fprintf(b, "\t\tproxy_pass http://%s;\n", target)
+		b.WriteString("\t\tproxy_set_header Host $host;\n")
+		b.WriteString("\t\tproxy_set_header X-Real-IP $remote_addr;\n")
+		b.WriteString("\t\tproxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;\n")
+		b.WriteString("\t\tproxy_set_header X-Forwarded-Proto $scheme;\n")
+		b.WriteString("\t}\n")
+	}
+
+	if len(route.Paths) == 0 {
+		location("/", route.ID)
+		return
+	}
+
+	for _, path := range route.Paths {
+		prefix := trimPath(path)
+		// a URI in proxy_pass replaces the matched part of the location
+		target := route.ID
+		if route.StripPrefix {
+			target += "/"
+		}
+
+		if prefix != "" {
+			location("= "+prefix, target)
+		}
+		location(prefix+"/", target)
+	}
+}
+
+// save writes the routes atomically and reloads nginx, nginx only includes *.conf files.
+// If the reload fails, the previous file is restored so it matches the config nginx still runs.
+func (n *Nginx) save(routes []Route) error {
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].ID < routes[j].ID
+	})
+
+	config, err := n.render(routes)
+	if err != nil {
+		return err
+	}
+
+	previous, err := os.ReadFile(n.path())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	existed := err == nil
+
+	err = n.write([]byte(config))
+	if err != nil {
+		return err
+	}
+
+	out, err := exec.Command("bash", "-c", n.ReloadCommand).CombinedOutput()
+	if err != nil {
+		reloadErr := fmt.Errorf("nginx reload failed: %s, err: %v", out, err)
+
+		restoreErr := os.Remove(n.path())
+		if existed {
+			restoreErr = n.write(previous)
+		}
+		if restoreErr != nil {
+			return fmt.Errorf("%v, restoring the previous config failed: %v", reloadErr, restoreErr)
+		}
+
+		return reloadErr
+	}
+
+	return nil
+}
+
+// write atomically replaces the generated file with data
+func (n *Nginx) write(data []byte) error {
+	err := os.WriteFile(n.path()+".tmp", data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(n.path()+".tmp", n.path())
+}
+
+// load parses the routes from the header of the generated file
+func (n *Nginx) load() ([]Route, error) {
+	file, err := os.Open(n.path())
+	if errors.Is(err, os.ErrNotExist) {
+		return []Route{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	routes := []Route{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, ok := strings.CutPrefix(scanner.Text(), nginxHeader)
+		if !ok {
+			break
+		}
+
+		var route Route
+		err := json.Unmarshal([]byte(line), &route)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route in %s, err: %v", n.path(), err)
+		}
+		routes = append(routes, route)
+	}
+
+	return routes, scanner.Err()
+}
+
+func (n *Nginx) Add(route Route) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	routes, err := n.load()
+	if err != nil {
+		return err
+	}
+
+	routes = slices.DeleteFunc(routes, func(existing Route) bool {
+		return existing.ID == route.ID
+	})
+
+	return n.save(append(routes, route))
+}
+
+func (n *Nginx) Remove(id string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	routes, err := n.load()
+	if err != nil {
+		return err
+	}
+
+	remaining := slices.DeleteFunc(slices.Clone(routes), func(existing Route) bool {
+		return existing.ID == id
+	})
+	if len(remaining) == len(routes) {
+		return nil
+	}
+
+	return n.save(remaining)
+}
+
+func (n *Nginx) Update(route Route) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	routes, err := n.load()
+	if err != nil {
+		return err
+	}
+
+	i := slices.IndexFunc(routes, func(existing Route) bool {
+		return existing.ID == route.ID
+	})
+	if i < 0 {
+		return errors.New("route not found")
+	}
+	routes[i] = route
+
+	return n.save(routes)
+}
+
+func (n *Nginx) Routes() ([]Route, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.load()
+}
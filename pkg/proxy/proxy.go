@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"fmt"
+	"hotify/pkg/caddy"
+	"hotify/pkg/config"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultCaddyAddress  = "http://localhost:2019"
+	DefaultCaddyServer   = "srv0"
+	DefaultBuiltinListen = ":80"
+	DefaultNginxListen   = "80"
+	DefaultNginxReload   = "nginx -s reload"
+)
+
+// or returns value, or fallback if it is empty
+func or(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+
+	return value
+}
+
+// Route forwards requests matching its hosts and paths to one of its upstreams
+type Route struct {
+	ID string `json:"id"`
+	// Hosts to match, eg. example.com, any host if empty
+	Hosts []string `json:"hosts"`
+	// Path prefixes to match, eg. /api, any path if empty
+	Paths []string `json:"paths"`
+	// Remove the matched path prefix before forwarding
+	StripPrefix bool `json:"stripPrefix"`
+	// Upstream addresses, eg. localhost:3000
+	Upstreams []string `json:"upstreams"`
+	// Policy selecting the upstream of a request, see config.LoadBalancingRoundRobin, round robin if empty
+	LoadBalancing string `json:"loadBalancing"`
+	// Passive health checks skip an upstream for FailDuration after MaxFails failed requests,
+	// disabled if FailDuration is 0
+	MaxFails     int           `json:"maxFails"`
+	FailDuration time.Duration `json:"failDuration"`
+}
+
+// Proxy is a reverse proxy backend the service routes are configured in
+type Proxy interface {
+	// Add adds the route, replacing a route with the same ID
+	Add(route Route) error
+	// Remove removes the route with the ID, removing a missing route is not an error
+	Remove(id string) error
+	// Update replaces an existing route, it fails if the route doesn't exist
+	Update(route Route) error
+	// Routes returns all routes owned by hotify, see Owned
+	Routes() ([]Route, error)
+}
+
+// RouteID returns the ID of the route for the proxy matcher at index of a service
+func RouteID(service string, index int) string {
+	return fmt.Sprintf("%s%s-%d", IDPrefix, service, index)
+}
+
+// RouteOf reports whether the route ID belongs to a service, as returned by RouteID
+func RouteOf(id string, service string) bool {
+	index, ok := strings.CutPrefix(id, IDPrefix+service+"-")
+	if !ok {
+		return false
+	}
+
+	_, err := strconv.Atoi(index)
+	return err == nil
+}
+
+// trimPath normalizes a path prefix, / becomes empty so it matches every path
+func trimPath(prefix string) string {
+	return strings.TrimSuffix(prefix, "/")
+}
+
+// NormalizePaths removes trailing slashes from path prefixes and drops prefixes matching every path
+func NormalizePaths(paths []string) []string {
+	var normalized []string
+	for _, path := range paths {
+		if path = trimPath(path); path != "" {
+			normalized = append(normalized, path)
+		}
+	}
+
+	return normalized
+}
+
+// matchPath reports whether path is prefix or below it
+func matchPath(path string, prefix string) bool {
+	prefix = trimPath(prefix)
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// New creates and initializes the proxy backend selected in the config. If Caddy can't be
+// reached, the proxy is returned along with the error and initializes on its next use.
+func New(conf config.ProxyBackendConfig) (Proxy, error) {
+	switch conf.Type {
+	case "", config.ProxyCaddy:
+		address := or(conf.CaddyAddress, DefaultCaddyAddress)
+		proxy := NewCaddy(caddy.NewClient(
+			or(conf.CaddyServer, DefaultCaddyServer),
+			address,
+		))
+		err := proxy.init()
+		if err != nil {
+			return proxy, fmt.Errorf("could not reach Caddy at %s: %v", address, err)
+		}
+
+		return proxy, nil
+	case config.ProxyBuiltin:
+		builtin := NewBuiltin(or(conf.Listen, DefaultBuiltinListen))
+		err := builtin.Start()
+		if err != nil {
+			return nil, err
+		}
+
+		return builtin, nil
+	case config.ProxyNginx:
+		if _, err := os.Stat(conf.NginxDir); err != nil {
+			return nil, fmt.Errorf("nginx site directory not found: %s, err: %v", conf.NginxDir, err)
+		}
+
+		return NewNginx(
+			conf.NginxDir,
+			or(conf.Listen, DefaultNginxListen),
+			or(conf.NginxReload, DefaultNginxReload),
+		), nil
+	}
+
+	return nil, fmt.Errorf("unknown proxy type: %s", conf.Type)
+}
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// IDPrefix marks routes created by hotify, other routes in the proxy are never touched
+const IDPrefix = "hotify-"
+
+// Owned reports whether the route with the ID was created by hotify. Routes created before
+// IDPrefix was introduced can't be told apart from routes configured by hand and are left alone.
+func Owned(id string) bool {
+	return strings.HasPrefix(id, IDPrefix)
+}
+
+const (
+	ChangeAdd    = "add"
+	ChangeUpdate = "update"
+	ChangeRemove = "remove"
+)
+
+// Change is a difference between the routes in the proxy and the desired routes
+type Change struct {
+	// add, update or remove
+	Action string `json:"action"`
+	// Desired route, or the route to remove
+	Route Route `json:"route"`
+	// Route currently in the proxy for updates
+	Current *Route `json:"current,omitempty"`
+}
+
+func (c Change) String() string {
+	switch c.Action {
+	case ChangeUpdate:
+		return fmt.Sprintf("update %s: %s (was %s)", c.Route.ID, c.Route.describe(), c.Current.describe())
+	default:
+		return fmt.Sprintf("%s %s: %s", c.Action, c.Route.ID, c.Route.describe())
+	}
+}
+
+func (r *Route) describe() string {
+	hosts := "*"
+	if len(r.Hosts) > 0 {
+		hosts = strings.Join(r.Hosts, ",")
+	}
+	paths := ""
+	if len(r.Paths) > 0 {
+		paths = strings.Join(r.Paths, ",")
+		if r.StripPrefix {
+			paths += " (strip)"
+		}
+	}
+
+	upstreams := strings.Join(r.Upstreams, ", ")
+	if r.LoadBalancing != "" {
+		upstreams += " (" + r.LoadBalancing + ")"
+	}
+
+	return fmt.Sprintf("%s%s -> %s", hosts, paths, upstreams)
+}
+
+// equal reports whether the routes match and forward the same requests
+func (r *Route) equal(other *Route) bool {
+	return slices.Equal(r.Hosts, other.Hosts) &&
+		slices.Equal(r.Paths, other.Paths) &&
+		r.StripPrefix == other.StripPrefix &&
+		slices.Equal(r.Upstreams, other.Upstreams) &&
+		r.LoadBalancing == other.LoadBalancing &&
+		r.MaxFails == other.MaxFails &&
+		r.FailDuration == other.FailDuration
+}
+
+// Diff returns the changes that turn the current hotify-owned routes into the desired ones.
+// Current routes with an ID in keep are left alone, eg. for services in the middle of a deployment.
+func Diff(current []Route, desired []Route, keep []string) []Change {
+	existing := make(map[string]Route, len(current))
+	for _, route := range current {
+		if Owned(route.ID) {
+			existing[route.ID] = route
+		}
+	}
+
+	changes := []Change{}
+	wanted := make(map[string]bool, len(desired))
+	for _, route := range desired {
+		wanted[route.ID] = true
+
+		currentRoute, ok := existing[route.ID]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Action: ChangeAdd, Route: route})
+		case !currentRoute.equal(&route):
+			changes = append(changes, Change{Action: ChangeUpdate, Route: route, Current: &currentRoute})
+		}
+	}
+
+	for id, route := range existing {
+		if !wanted[id] && !slices.Contains(keep, id) {
+			changes = append(changes, Change{Action: ChangeRemove, Route: route})
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Route.ID < changes[j].Route.ID
+	})
+
+	return changes
+}
+
+// Apply makes the changes in the proxy, continuing after failures
+func Apply(proxy Proxy, changes []Change) error {
+	var errs []error
+	for _, change := range changes {
+		errs = append(errs, ApplyChange(proxy, change))
+	}
+
+	return errors.Join(errs...)
+}
+
+// ApplyChange makes a single change in the proxy
+func ApplyChange(proxy Proxy, change Change) error {
+	var err error
+	switch change.Action {
+	case ChangeAdd, ChangeUpdate:
+		err = proxy.Add(change.Route)
+	case ChangeRemove:
+		err = proxy.Remove(change.Route.ID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to %s, err: %v", change, err)
+	}
+
+	return nil
+}
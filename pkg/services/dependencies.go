@@ -0,0 +1,72 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// DependencyTimeout is how long a service waits for its dependencies to be ready
+const DependencyTimeout = 5 * time.Minute
+
+// ready reports whether the service can be used by services depending on it.
+// With a health check, the service only becomes running once the check passed.
+func (s *Service) ready() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.Status == ServiceStatusRunning && s.Health != HealthUnhealthy
+}
+
+// failed reports whether the service failed and won't become ready by itself
+func (s *Service) failed() bool {
+	status, _ := s.status()
+	return status == ServiceStatusFailed
+}
+
+// waitForDependencies blocks until every dependency of the service is ready
+func (m *Manager) waitForDependencies(service *Service) error {
+	deadline := time.Now().Add(DependencyTimeout)
+
+	for _, name := range service.Config.DependsOn {
+		logged := false
+		for {
+			dependency := m.Service(name)
+			if dependency != nil && dependency.ready() {
+				break
+			}
+			if dependency != nil && dependency.failed() {
+				return fmt.Errorf("dependency failed: %s", name)
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("timed out waiting for dependency: %s", name)
+			}
+
+			if !logged {
+				slog.Info("Waiting for dependency", "name", service.Config.Name, "dependency", name)
+				logged = true
+			}
+			time.Sleep(time.Second)
+		}
+	}
+
+	return nil
+}
+
+// stopOrder returns the services in reverse start order, so dependents stop before their dependencies
+func (m *Manager) stopOrder() []*Service {
+	services := m.Services()
+
+	order, err := m.Config.StartOrder()
+	if err != nil {
+		// the config is validated on load, fall back to any order
+		return services
+	}
+
+	slices.SortFunc(services, func(a, b *Service) int {
+		return slices.Index(order, b.Config.Name) - slices.Index(order, a.Config.Name)
+	})
+
+	return services
+}
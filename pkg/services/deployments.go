@@ -0,0 +1,139 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"time"
+)
+
+type Trigger string
+
+type DeploymentState string
+
+// Number of deployments kept in the history, the build output is kept in the service
+// logs and can be found by the deployment ID
+const MaxDeployments = 100
+
+const (
+	TriggerStartup  Trigger = "startup"
+	TriggerAPI      Trigger = "api"
+	TriggerWebhook  Trigger = "webhook"
+	TriggerRollback Trigger = "rollback"
+)
+
+const (
+	DeploymentStateBuilding DeploymentState = "building"
+	DeploymentStateFailed   DeploymentState = "failed"
+	DeploymentStateDeployed DeploymentState = "deployed"
+)
+
+// Deployment is a build of a service, it is added when the build starts
+type Deployment struct {
+	// Sequential number of the deployment, starting at 1
+	ID int `json:"id"`
+	// Commit hash the deployment was built from
+	Commit string `json:"commit"`
+	// Time the build started, or finished once it did
+	Time time.Time `json:"time"`
+	// What caused the deployment
+	Trigger Trigger `json:"trigger"`
+	// Whether the deployment is building, failed or was deployed
+	State DeploymentState `json:"state"`
+	// Whether the build artifacts are still kept, so rollbacks don't need a rebuild
+	Artifacts bool `json:"artifacts"`
+}
+
+type DeploymentHistory struct {
+	// ID of the active deployment, 0 if there is none
+	Current int `json:"current"`
+	// Whether the active deployment is a rollback, restarts keep it until the next deployment
+	Pinned      bool         `json:"pinned"`
+	Deployments []Deployment `json:"deployments"`
+}
+
+// LoadDeploymentHistory reads the history at path, a missing file results in an empty history
+func LoadDeploymentHistory(path string) (*DeploymentHistory, error) {
+	history := &DeploymentHistory{
+		Deployments: []Deployment{},
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return history, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, history)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range history.Deployments {
+		switch history.Deployments[i].State {
+		// deployments recorded before the state existed were successful
+		case "":
+			history.Deployments[i].State = DeploymentStateDeployed
+		// the build was interrupted by a restart of hotify
+		case DeploymentStateBuilding:
+			history.Deployments[i].State = DeploymentStateFailed
+		}
+	}
+
+	return history, nil
+}
+
+// Save writes the history to a temporary file and renames it, so a crash never leaves a partial history
+func (h *DeploymentHistory) Save(path string) error {
+	data, err := json.MarshalIndent(h, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// NextID returns the ID the next added deployment will get
+func (h *DeploymentHistory) NextID() int {
+	if len(h.Deployments) == 0 {
+		return 1
+	}
+
+	return h.Deployments[len(h.Deployments)-1].ID + 1
+}
+
+// Add assigns the next ID to the deployment and appends it. The oldest deployments
+// beyond MaxDeployments are dropped, except the active one.
+func (h *DeploymentHistory) Add(deployment Deployment) Deployment {
+	deployment.ID = h.NextID()
+
+	h.Deployments = append(h.Deployments, deployment)
+	for i := 0; len(h.Deployments) > MaxDeployments && i < len(h.Deployments); {
+		if h.Deployments[i].ID == h.Current {
+			i++
+			continue
+		}
+		h.Deployments = slices.Delete(h.Deployments, i, i+1)
+	}
+
+	return deployment
+}
+
+func (h *DeploymentHistory) Get(id int) *Deployment {
+	for i := range h.Deployments {
+		if h.Deployments[i].ID == id {
+			return &h.Deployments[i]
+		}
+	}
+
+	return nil
+}
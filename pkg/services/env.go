@@ -0,0 +1,48 @@
+package services
+
+import (
+	"fmt"
+	"hotify/pkg/dotenv"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Environ builds the environment for the build and exec commands running in dir.
+// Values are merged in order: the hotify process environment, the env files in the
+// order they are listed (relative to dir), and finally the Env map of the service config.
+func (s *Service) Environ(dir string) ([]string, error) {
+	merged := make(map[string]string)
+
+	for _, file := range s.Config.EnvFile {
+		path := file
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+
+		env, err := dotenv.Load(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load env file: %v", err)
+		}
+		for key, value := range env {
+			merged[key] = value
+		}
+	}
+
+	for key, value := range s.Config.Env {
+		merged[key] = value
+	}
+
+	keys := make([]string, 0, len(merged))
+	for key := range merged {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	environ := os.Environ()
+	for _, key := range keys {
+		environ = append(environ, fmt.Sprintf("%s=%s", key, merged[key]))
+	}
+
+	return environ, nil
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"errors"
+	"hotify/pkg/health"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+type HealthStatus string
+
+const (
+	// No health check configured or the service is not running
+	HealthNone      HealthStatus = "none"
+	HealthStarting  HealthStatus = "starting"
+	HealthHealthy   HealthStatus = "healthy"
+	HealthUnhealthy HealthStatus = "unhealthy"
+)
+
+// startHealthCheck monitors the replica until stopHealthCheck is called
+func (s *Service) startHealthCheck(replica *Replica) {
+	s.stopHealthCheck(replica)
+
+	if s.Config.HealthCheck.Type == "" {
+		return
+	}
+
+	stop := make(chan struct{})
+	replica.healthStop = stop
+	s.setHealth(replica, HealthStarting)
+
+	go s.monitorHealth(replica, stop)
+}
+
+func (s *Service) stopHealthCheck(replicas ...*Replica) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, replica := range replicas {
+		if replica.healthStop != nil {
+			close(replica.healthStop)
+			replica.healthStop = nil
+		}
+
+		replica.Health = HealthNone
+	}
+
+	s.updateHealth()
+}
+
+// setHealth records the health of a replica and updates the health of the service
+func (s *Service) setHealth(replica *Replica, health HealthStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	replica.Health = health
+	s.updateHealth()
+}
+
+// updateHealth sets the health of the service to the worst health of its replicas, s.mu must be held
+func (s *Service) updateHealth() {
+	health := HealthNone
+	for _, replica := range s.Replicas {
+		switch {
+		case replica.Health == HealthUnhealthy:
+			health = HealthUnhealthy
+		case replica.Health == HealthStarting && health != HealthUnhealthy:
+			health = HealthStarting
+		case replica.Health == HealthHealthy && health == HealthNone:
+			health = HealthHealthy
+		}
+	}
+
+	s.Health = health
+}
+
+func (s *Service) monitorHealth(replica *Replica, stop <-chan struct{}) {
+	check := &s.Config.HealthCheck
+	retries := check.Retries
+	if retries <= 0 {
+		retries = health.DefaultRetries
+	}
+
+	started := time.Now()
+	failures := 0
+
+	ticker := time.NewTicker(check.Interval.Or(health.DefaultInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		dir := s.WorkDir()
+		env, err := s.processEnv(dir, replica.Index, replica.Upstream)
+		if err == nil {
+			err = health.Check(check, dir, env, replica.Upstream)
+		}
+
+		if err == nil {
+			failures = 0
+			s.setHealth(replica, HealthHealthy)
+			// with replicas, the service is running once all of them passed
+			s.mu.Lock()
+			if s.Status == ServiceStatusStarting && !s.rolling && slices.Contains(s.Replicas, replica) && s.Health == HealthHealthy {
+				s.updateStatus(ServiceStatusRunning, "health check passed")
+			}
+			s.mu.Unlock()
+			continue
+		}
+
+		// failures while starting up don't count until the first success
+		s.mu.Lock()
+		starting := replica.Health == HealthStarting
+		s.mu.Unlock()
+		if starting && time.Since(started) < time.Duration(check.StartPeriod) {
+			continue
+		}
+
+		failures++
+		slog.Warn("Health check failed", "name", s.Config.Name, "replica", replica.Index, "failures", failures, "error", err)
+
+		if failures < retries {
+			continue
+		}
+
+		s.setHealth(replica, HealthUnhealthy)
+
+		if check.Restart {
+			// killing the process hands it over to the restart logic in watch
+			slog.Error("Service is unhealthy, restarting", "name", s.Config.Name, "replica", replica.Index)
+			replica.process.Kill()
+			return
+		}
+	}
+}
+
+// waitReady runs the health check against a new replica until it succeeds once.
+// It fails if the process exits or the check keeps failing for longer than the
+// start period plus the configured retries.
+func (s *Service) waitReady(replica *Replica, dir string) error {
+	check := &s.Config.HealthCheck
+	retries := check.Retries
+	if retries <= 0 {
+		retries = health.DefaultRetries
+	}
+	interval := check.Interval.Or(health.DefaultInterval)
+	deadline := time.Now().Add(time.Duration(check.StartPeriod) + time.Duration(retries)*interval)
+
+	env, err := s.processEnv(dir, replica.Index, replica.Upstream)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-replica.exited:
+			return errors.New("process exited")
+		case <-time.After(time.Second):
+		}
+
+		err := health.Check(check, dir, env, replica.Upstream)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+	}
+}
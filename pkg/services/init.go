@@ -0,0 +1,223 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultInitWorkers is the number of services initialized concurrently if Config.InitWorkers is not set
+const DefaultInitWorkers = 4
+
+type InitState string
+
+const (
+	// Waiting for a worker or for dependencies to finish initializing
+	InitStatePending      InitState = "pending"
+	InitStateInitializing InitState = "initializing"
+	InitStateDone         InitState = "done"
+	InitStateFailed       InitState = "failed"
+)
+
+// ServiceInit is the initialization progress of a single service
+type ServiceInit struct {
+	State    InitState  `json:"state"`
+	Error    string     `json:"error"`
+	Started  *time.Time `json:"started"`
+	Finished *time.Time `json:"finished"`
+}
+
+// InitProgress is the progress of the service initialization at startup
+type InitProgress struct {
+	// Whether services are still being initialized
+	Running  bool                    `json:"running"`
+	Started  time.Time               `json:"started"`
+	Finished *time.Time              `json:"finished"`
+	Services map[string]*ServiceInit `json:"services"`
+}
+
+// Done returns the number of services that finished initializing, successfully or not
+func (p *InitProgress) Done() int {
+	done := 0
+	for _, service := range p.Services {
+		if service.State == InitStateDone || service.State == InitStateFailed {
+			done++
+		}
+	}
+
+	return done
+}
+
+// InitProgress returns a copy of the startup initialization progress
+func (m *Manager) InitProgress() InitProgress {
+	m.initMu.Lock()
+	defer m.initMu.Unlock()
+
+	progress := m.init
+	progress.Services = make(map[string]*ServiceInit, len(m.init.Services))
+	for name, service := range m.init.Services {
+		copied := *service
+		progress.Services[name] = &copied
+	}
+
+	return progress
+}
+
+// ErrInitializing is returned by reloads before the startup initialization finished
+var ErrInitializing = errors.New("services are still being initialized")
+
+// initialized reports whether the startup initialization finished, successfully or not
+func (m *Manager) initialized() bool {
+	m.initMu.Lock()
+	defer m.initMu.Unlock()
+
+	return m.init.Finished != nil
+}
+
+// setInitState updates the initialization progress of a service
+func (m *Manager) setInitState(name string, state InitState, err error) {
+	m.initMu.Lock()
+	defer m.initMu.Unlock()
+
+	service := m.init.Services[name]
+	if service == nil {
+		return
+	}
+
+	now := time.Now()
+	service.State = state
+	switch state {
+	case InitStateInitializing:
+		service.Started = &now
+	case InitStateDone, InitStateFailed:
+		service.Finished = &now
+	}
+	if err != nil {
+		service.Error = err.Error()
+	}
+}
+
+// Init initializes all services concurrently, limited by Config.InitWorkers.
+// A service is only initialized once its dependencies are running. Services that
+// fail are marked as failed and don't affect the others, their errors are returned joined.
+func (m *Manager) Init() error {
+	defer func() {
+		m.initMu.Lock()
+		defer m.initMu.Unlock()
+
+		now := time.Now()
+		m.init.Running = false
+		m.init.Finished = &now
+	}()
+
+	order, err := m.Config.StartOrder()
+	if err != nil {
+		return err
+	}
+
+	workers := m.Config.InitWorkers
+	if workers <= 0 {
+		workers = DefaultInitWorkers
+	}
+
+	m.initMu.Lock()
+	m.init = InitProgress{
+		Running:  true,
+		Started:  time.Now(),
+		Services: make(map[string]*ServiceInit, len(order)),
+	}
+	for _, name := range order {
+		m.init.Services[name] = &ServiceInit{State: InitStatePending}
+	}
+	m.initMu.Unlock()
+
+	// register all services first, so the API lists them while they are initialized
+	done := make(map[string]chan struct{}, len(order))
+	for _, name := range order {
+		serviceConfig := m.Config.Services[name]
+		service := NewService(
+			serviceConfig,
+			filepath.Join(m.Config.ServicesPath, serviceConfig.Name),
+			m.Proxy,
+			m.logOptions(),
+		)
+		service.setStatus(ServiceStatusPending, "waiting for initialization")
+		m.setService(name, service)
+		done[name] = make(chan struct{})
+	}
+
+	var (
+		wg       sync.WaitGroup
+		errsMu   sync.Mutex
+		errs     []error
+		failedMu sync.Mutex
+		failed   = map[string]bool{}
+		slots    = make(chan struct{}, workers)
+	)
+
+	for _, name := range order {
+		service := m.Service(name)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[name])
+
+			err := m.initOne(service, done, slots, func(dependency string) bool {
+				failedMu.Lock()
+				defer failedMu.Unlock()
+				return failed[dependency]
+			})
+			if err != nil {
+				slog.Error("Failed to initialize service", "name", name, "error", err)
+				service.fail(fmt.Sprintf("initialization failed: %v", err))
+				m.setInitState(name, InitStateFailed, err)
+
+				failedMu.Lock()
+				failed[name] = true
+				failedMu.Unlock()
+
+				errsMu.Lock()
+				errs = append(errs, fmt.Errorf("failed to initialize service: %s, err: %v", name, err))
+				errsMu.Unlock()
+				return
+			}
+
+			m.setInitState(name, InitStateDone, nil)
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// initOne waits until the dependencies of the service finished initializing,
+// then initializes it once a worker slot is free
+func (m *Manager) initOne(
+	service *Service,
+	done map[string]chan struct{},
+	slots chan struct{},
+	failed func(dependency string) bool,
+) error {
+	for _, dependency := range service.Config.DependsOn {
+		<-done[dependency]
+		if failed(dependency) {
+			return fmt.Errorf("dependency failed: %s", dependency)
+		}
+	}
+
+	slots <- struct{}{}
+	defer func() { <-slots }()
+
+	err := m.waitForDependencies(service)
+	if err != nil {
+		return err
+	}
+
+	m.setInitState(service.Config.Name, InitStateInitializing, nil)
+
+	return m.InitService(service, TriggerStartup)
+}
@@ -1,57 +1,98 @@
 package services
 
 import (
+	"crypto/subtle"
 	"errors"
-	"hotify/pkg/caddy"
+	"fmt"
 	"hotify/pkg/config"
 	"hotify/pkg/git"
+	"hotify/pkg/logs"
+	"hotify/pkg/proxy"
 	"log/slog"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 )
 
 type Manager struct {
 	Config   *config.Config
-	Caddy    *caddy.Client
+	Proxy    proxy.Proxy
 	services map[string]*Service
 	mu       sync.Mutex
+	// guards the services map only, so lookups don't wait for long running operations
+	servicesMu sync.RWMutex
+	// guards every change and save of Config, only held briefly so token lookups
+	// aren't blocked while a service is created
+	configMu sync.RWMutex
+	// serializes proxy reconciliations, it is never held during a build
+	reconcileMu sync.Mutex
+	init        InitProgress
+	initMu      sync.Mutex
 }
 
-func NewManager(config *config.Config, caddy *caddy.Client) *Manager {
+func NewManager(config *config.Config, proxy proxy.Proxy) *Manager {
 	return &Manager{
 		Config:   config,
-		Caddy:    caddy,
+		Proxy:    proxy,
 		services: make(map[string]*Service),
 	}
 }
 
-func (m *Manager) InitService(service *Service) error {
+func (m *Manager) logOptions() logs.Options {
+	return logs.Options{
+		MaxSize:        int64(m.Config.Logs.MaxSize) * 1024 * 1024,
+		RotateInterval: time.Duration(m.Config.Logs.RotateInterval),
+		MaxAge:         time.Duration(m.Config.Logs.MaxAge),
+		MaxFiles:       m.Config.Logs.MaxFiles,
+		BufferLines:    m.Config.Logs.BufferLines,
+	}
+}
+
+// updateConfig applies update to the config and saves it, unless update returns an error.
+// A nil update only saves the config.
+func (m *Manager) updateConfig(update func() error) error {
+	m.configMu.Lock()
+	defer m.configMu.Unlock()
+
+	if update != nil {
+		err := update()
+		if err != nil {
+			return err
+		}
+	}
+
+	return m.Config.Save(m.Config.LoadPath)
+}
+
+func (m *Manager) InitService(service *Service, trigger Trigger) error {
 	err := service.Init()
 	if err != nil {
 		return err
 	}
 
-	isNewestCommit, err := git.IsNewestCommit(service.Path)
+	isNewestCommit, err := git.IsNewestCommit(service.RepoPath(), service.Ref())
 	if err != nil {
 		return err
 	}
-	if !isNewestCommit || service.Config.InitialBuild {
-		err = service.Pull()
+	// a rolled back service keeps its deployment until it is deployed again
+	outdated := !isNewestCommit && !service.History.Pinned
+	if outdated || service.Config.InitialBuild || service.History.Current == 0 {
+		err = service.Update(trigger)
 		if err != nil {
 			return err
 		}
 
-		err = service.Build()
-		if err != nil {
-			return err
-		}
+		m.updateConfig(func() error {
+			service.Config.InitialBuild = false
+			return nil
+		})
 
-		service.Config.InitialBuild = false
-		m.Config.Save(m.Config.LoadPath)
-	} else {
-		slog.Info("Service is up to date, skipping build", "name", service.Config.Name)
+		return nil
 	}
 
+	slog.Info("Service is up to date, skipping build", "name", service.Config.Name)
+
 	err = service.Start()
 	if err != nil {
 		return err
@@ -60,41 +101,30 @@ func (m *Manager) InitService(service *Service) error {
 	return nil
 }
 
-func (m *Manager) Init() error {
-	for key, serviceConfig := range m.Config.Services {
-		service := NewService(
-			serviceConfig,
-			filepath.Join(m.Config.ServicesPath, serviceConfig.Name),
-			m.Caddy,
-		)
-		err := m.InitService(service)
-		if err != nil {
-			return err
-		}
-
-		m.mu.Lock()
-		m.services[key] = service
-		m.mu.Unlock()
-	}
-
-	return nil
-}
-
+// Stop stops all services, dependents before their dependencies
 func (m *Manager) Stop() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	for _, service := range m.services {
+	for _, service := range m.stopOrder() {
 		err := service.Stop()
 		if err != nil {
 			return err
 		}
+
+		err = service.Logs.Close()
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
 func (m *Manager) Services() []*Service {
+	m.servicesMu.RLock()
+	defer m.servicesMu.RUnlock()
+
 	var services []*Service
 	for _, service := range m.services {
 		services = append(services, service)
@@ -104,9 +134,26 @@ func (m *Manager) Services() []*Service {
 }
 
 func (m *Manager) Service(name string) *Service {
+	m.servicesMu.RLock()
+	defer m.servicesMu.RUnlock()
+
 	return m.services[name]
 }
 
+func (m *Manager) setService(name string, service *Service) {
+	m.servicesMu.Lock()
+	defer m.servicesMu.Unlock()
+
+	m.services[name] = service
+}
+
+func (m *Manager) removeService(name string) {
+	m.servicesMu.Lock()
+	defer m.servicesMu.Unlock()
+
+	delete(m.services, name)
+}
+
 func (m *Manager) Create(config *config.ServiceConfig) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -115,8 +162,15 @@ func (m *Manager) Create(config *config.ServiceConfig) error {
 		return errors.New("service already exists")
 	}
 
-	m.Config.Services[config.Name] = config
-	err := m.Config.Save(m.Config.LoadPath)
+	err := m.Config.ValidateService(config)
+	if err != nil {
+		return err
+	}
+
+	err = m.updateConfig(func() error {
+		m.Config.Services[config.Name] = config
+		return nil
+	})
 	if err != nil {
 		return err
 	}
@@ -124,16 +178,20 @@ func (m *Manager) Create(config *config.ServiceConfig) error {
 	service := NewService(
 		config,
 		filepath.Join(m.Config.ServicesPath, config.Name),
-		m.Caddy,
+		m.Proxy,
+		m.logOptions(),
 	)
 
-	err = m.InitService(service)
+	// registered before it is initialized, so proxy reconciliation keeps its routes
+	service.setStatus(ServiceStatusPending, "waiting for initialization")
+	m.setService(config.Name, service)
+
+	err = m.InitService(service, TriggerAPI)
 	if err != nil {
+		m.removeService(config.Name)
 		return err
 	}
 
-	m.services[config.Name] = service
-
 	return nil
 }
 
@@ -142,6 +200,9 @@ func (m *Manager) Delete(name string) error {
 	if service == nil {
 		return errors.New("service not found")
 	}
+	if dependents := m.Config.Dependents(name); len(dependents) > 0 {
+		return fmt.Errorf("service is required by: %s", strings.Join(dependents, ", "))
+	}
 
 	err := service.Remove()
 	if err != nil {
@@ -151,13 +212,177 @@ func (m *Manager) Delete(name string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	delete(m.services, name)
-	delete(m.Config.Services, name)
+	m.removeService(name)
+
+	return m.updateConfig(func() error {
+		delete(m.Config.Services, name)
+		return nil
+	})
+}
+
+// SetEnv validates and replaces the environment configuration of a service and saves the config.
+// Values equal to config.RedactedValue keep their current value, so redacted
+// configs can be sent back unchanged. Changes apply on the next build or start.
+func (m *Manager) SetEnv(name string, env map[string]string, envFile []string, secretEnv []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	service := m.Service(name)
+	if service == nil {
+		return errors.New("service not found")
+	}
+
+	return m.updateConfig(func() error {
+		for key, value := range env {
+			if value == config.RedactedValue {
+				env[key] = service.Config.Env[key]
+			}
+		}
 
-	err = m.Config.Save(m.Config.LoadPath)
+		// an invalid config would be saved and fail to load on the next start
+		candidate := *service.Config
+		candidate.Env = env
+		candidate.EnvFile = envFile
+		candidate.SecretEnv = secretEnv
+		err := m.Config.ValidateService(&candidate)
+		if err != nil {
+			return err
+		}
+
+		service.Config.Env = env
+		service.Config.EnvFile = envFile
+		service.Config.SecretEnv = secretEnv
+
+		return nil
+	})
+}
+
+// Edit replaces the config of a service, applies the changes and saves the config. The webhook
+// secret and env values equal to config.RedactedValue keep their current value. The name can't be changed.
+func (m *Manager) Edit(name string, serviceConfig *config.ServiceConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	service := m.Service(name)
+	if service == nil {
+		return errors.New("service not found")
+	}
+	if serviceConfig.Name != name {
+		return errors.New("service name can't be changed")
+	}
+	err := m.Config.ValidateService(serviceConfig)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	for key, value := range serviceConfig.Env {
+		if value == config.RedactedValue {
+			serviceConfig.Env[key] = service.Config.Env[key]
+		}
+	}
+	if serviceConfig.Secret == config.RedactedValue {
+		serviceConfig.Secret = service.Config.Secret
+	}
+
+	// the config is replaced even if applying it fails, so it is saved either way
+	err = service.Reconfigure(serviceConfig)
+	saveErr := m.updateConfig(nil)
+
+	return errors.Join(err, saveErr)
+}
+
+// CreateToken generates a named API token and saves its hash in the config.
+// The token is returned once and can't be recovered later.
+func (m *Manager) CreateToken(name string, scope config.Scope, services []string) (string, error) {
+	if name == "" {
+		return "", errors.New("token name is required")
+	}
+	if !scope.Valid() {
+		return "", fmt.Errorf("invalid scope: %s", scope)
+	}
+
+	token, hash, err := config.NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = m.updateConfig(func() error {
+		if _, ok := m.Config.Tokens[name]; ok {
+			return errors.New("token already exists")
+		}
+
+		if m.Config.Tokens == nil {
+			m.Config.Tokens = map[string]*config.TokenConfig{}
+		}
+		m.Config.Tokens[name] = &config.TokenConfig{
+			Hash:     hash,
+			Scope:    scope,
+			Services: services,
+			Created:  time.Now(),
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ErrLastToken is returned when revoking the only way to access the API
+var ErrLastToken = errors.New("the last token can't be revoked without a secret")
+
+// RevokeToken deletes a named API token and saves the config
+func (m *Manager) RevokeToken(name string) error {
+	return m.updateConfig(func() error {
+		if _, ok := m.Config.Tokens[name]; !ok {
+			return errors.New("token not found")
+		}
+		if m.Config.Secret == "" && len(m.Config.Tokens) == 1 {
+			return ErrLastToken
+		}
+
+		delete(m.Config.Tokens, name)
+
+		return nil
+	})
+}
+
+// RedactedConfig returns a copy of the config with its secrets hidden
+func (m *Manager) RedactedConfig() *config.Config {
+	m.configMu.RLock()
+	defer m.configMu.RUnlock()
+
+	return m.Config.Redacted()
+}
+
+// Token returns the name and config of the token matching the given value, nil if there is none
+func (m *Manager) Token(token string) (string, *config.TokenConfig) {
+	m.configMu.RLock()
+	defer m.configMu.RUnlock()
+
+	hash := config.HashToken(token)
+	for name, tokenConfig := range m.Config.Tokens {
+		if subtle.ConstantTimeCompare([]byte(tokenConfig.Hash), []byte(hash)) == 1 {
+			return name, tokenConfig
+		}
+	}
+
+	return "", nil
+}
+
+// Tokens returns copies of all API tokens with their hashes hidden
+func (m *Manager) Tokens() map[string]*config.TokenConfig {
+	m.configMu.RLock()
+	defer m.configMu.RUnlock()
+
+	tokens := make(map[string]*config.TokenConfig, len(m.Config.Tokens))
+	for name, token := range m.Config.Tokens {
+		redacted := *token
+		redacted.Hash = config.RedactedValue
+		tokens[name] = &redacted
+	}
+
+	return tokens
 }
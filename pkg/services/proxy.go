@@ -0,0 +1,161 @@
+package services
+
+import (
+	"errors"
+	"hotify/pkg/proxy"
+	"log/slog"
+	"maps"
+	"time"
+)
+
+// DefaultReconcileInterval is the time between proxy reconciliations if Proxy.ReconcileInterval is not set
+const DefaultReconcileInterval = time.Minute
+
+// routed reports whether the proxy route of the service should exist. The route is
+// kept while a crashed service is restarted, so requests wait instead of failing.
+func (s *Service) routed() bool {
+	if !s.Config.Proxy.Enabled() {
+		return false
+	}
+
+	switch status, _ := s.status(); status {
+	case ServiceStatusStarting, ServiceStatusCrashed, ServiceStatusBackingOff:
+		return true
+	}
+
+	return s.running()
+}
+
+// busy reports whether the service is changing its route right now
+func (s *Service) busy() bool {
+	switch status, _ := s.status(); status {
+	case ServiceStatusPending, ServiceStatusCloning, ServiceStatusPulling, ServiceStatusBuilding,
+		ServiceStatusStarting, ServiceStatusStopping:
+		return true
+	}
+
+	return false
+}
+
+// ProxyChanges compares the routes in the proxy with the routes the services should have.
+// Services in the middle of an operation are skipped, their routes are left as they are.
+func (m *Manager) ProxyChanges() ([]proxy.Change, error) {
+	current, err := m.Proxy.Routes()
+	if err != nil {
+		return nil, err
+	}
+
+	m.servicesMu.RLock()
+	services := maps.Clone(m.services)
+	m.servicesMu.RUnlock()
+
+	var desired []proxy.Route
+	var keep []string
+	for name, service := range services {
+		// an operation may be changing the config, the routes are left alone without waiting for it
+		if !service.deployMu.TryLock() {
+			for _, route := range current {
+				if proxy.RouteOf(route.ID, name) {
+					keep = append(keep, route.ID)
+				}
+			}
+			continue
+		}
+
+		for _, route := range service.routes(service.Upstreams()) {
+			if service.busy() {
+				keep = append(keep, route.ID)
+			} else if service.routed() {
+				desired = append(desired, route)
+			}
+		}
+		service.deployMu.Unlock()
+	}
+
+	return proxy.Diff(current, desired, keep), nil
+}
+
+// syncedProxy records the result of a reconciliation on the service, unless an operation is changing it
+func (s *Service) syncedProxy(failed map[string]error) {
+	if !s.deployMu.TryLock() {
+		return
+	}
+	defer s.deployMu.Unlock()
+
+	if !s.Config.Proxy.Enabled() || s.busy() {
+		return
+	}
+
+	var errs []error
+	for _, route := range s.routes(s.Upstreams()) {
+		errs = append(errs, failed[route.ID])
+	}
+	s.proxySynced(errors.Join(errs...))
+}
+
+// unreachableProxy shows err on the service if it should have a route, unless an operation is changing it
+func (s *Service) unreachableProxy(err error) {
+	if !s.deployMu.TryLock() {
+		return
+	}
+	defer s.deployMu.Unlock()
+
+	if s.routed() && !s.busy() {
+		s.proxySynced(err)
+	}
+}
+
+// ReconcileProxy adds missing routes, fixes drifted ones and removes routes of
+// services that no longer exist. With dryRun, the changes are only returned.
+func (m *Manager) ReconcileProxy(dryRun bool) ([]proxy.Change, error) {
+	m.reconcileMu.Lock()
+	defer m.reconcileMu.Unlock()
+
+	changes, err := m.ProxyChanges()
+	if dryRun {
+		return changes, err
+	}
+	if err != nil {
+		// most likely the proxy is unreachable, show it on every service with a route
+		for _, service := range m.Services() {
+			service.unreachableProxy(err)
+		}
+		return nil, err
+	}
+
+	failed := map[string]error{}
+	var errs []error
+	for _, change := range changes {
+		slog.Info("Reconciling proxy route", "change", change.String())
+
+		err := proxy.ApplyChange(m.Proxy, change)
+		if err != nil {
+			failed[change.Route.ID] = err
+			errs = append(errs, err)
+		}
+	}
+
+	for _, service := range m.Services() {
+		service.syncedProxy(failed)
+	}
+
+	return changes, errors.Join(errs...)
+}
+
+// WatchProxy reconciles the proxy routes periodically until stop is closed
+func (m *Manager) WatchProxy(stop <-chan struct{}) {
+	interval := m.Config.Proxy.ReconcileInterval.Or(DefaultReconcileInterval)
+
+	for {
+		_, err := m.ReconcileProxy(false)
+		if err != nil {
+			slog.Error("Failed to reconcile proxy routes", "error", err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"hotify/pkg/config"
+	"log/slog"
+	"os"
+)
+
+// Reconfigure replaces the service config and applies the side effects of the changes:
+// a new repository is cloned again, ref or build changes are deployed, changes to how the
+// process runs restart it and proxy changes update the route. A stopped service stays stopped.
+func (s *Service) Reconfigure(next *config.ServiceConfig) error {
+	s.deployMu.Lock()
+	defer s.deployMu.Unlock()
+
+	previous := *s.Config
+	updated := *next
+	// InitialBuild only matters on startup
+	updated.InitialBuild = false
+	previous.InitialBuild = false
+
+	if config.Equal(previous, updated) {
+		return nil
+	}
+
+	slog.Info("Reconfiguring service", "name", s.Config.Name)
+
+	repoChanged := previous.Repo != updated.Repo
+	refChanged := previous.Branch != updated.Branch ||
+		previous.Tag != updated.Tag ||
+		previous.Commit != updated.Commit
+	buildChanged := previous.Build != updated.Build
+	runChanged := previous.Exec != updated.Exec ||
+		!config.Equal(previous.Env, updated.Env) ||
+		!config.Equal(previous.EnvFile, updated.EnvFile) ||
+		previous.HealthCheck != updated.HealthCheck ||
+		previous.Replicas != updated.Replicas ||
+		previous.Deploy != updated.Deploy ||
+		// the upstreams decide the PORT of each process
+		previous.Proxy.Upstream != updated.Proxy.Upstream ||
+		previous.Proxy.AlternateUpstream != updated.Proxy.AlternateUpstream
+	proxyChanged := !config.Equal(previous.Proxy, updated.Proxy)
+	active := s.running()
+
+	// the old routes have to be removed while the old matchers are still known
+	if proxyChanged && active {
+		err := s.RemoveProxy()
+		if err != nil {
+			return err
+		}
+	}
+
+	*s.Config = updated
+
+	if proxyChanged && active {
+		err := s.AddProxy()
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case repoChanged:
+		err := s.reclone()
+		if err != nil {
+			return err
+		}
+		if active {
+			return s.deploy(TriggerAPI)
+		}
+	case refChanged:
+		if active {
+			return s.update(TriggerAPI)
+		}
+		return s.Pull()
+	case buildChanged && active:
+		return s.deploy(TriggerAPI)
+	case runChanged && active:
+		return s.restart()
+	}
+
+	return nil
+}
+
+// reclone replaces the repository with a fresh clone of the configured repository
+func (s *Service) reclone() error {
+	err := os.RemoveAll(s.RepoPath())
+	if err != nil {
+		return err
+	}
+
+	return s.Clone()
+}
@@ -0,0 +1,495 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"hotify/pkg/config"
+	"hotify/pkg/git"
+	"hotify/pkg/logs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// Number of releases kept if the service config doesn't specify it
+	DefaultKeepReleases = 5
+	// Time the old process keeps serving open connections after a blue/green switch
+	DefaultDrainTime = 5 * time.Second
+	// Suffix of the directory a release is built in before it replaces the release
+	stagingSuffix = ".new"
+)
+
+// ReleasePath returns the location of the build artifacts for a commit
+func (s *Service) ReleasePath(commit string) string {
+	return filepath.Join(s.Path, "releases", commit)
+}
+
+// CurrentPath returns the location of the symlink to the active release
+func (s *Service) CurrentPath() string {
+	return filepath.Join(s.Path, "current")
+}
+
+// WorkDir returns the directory the service is executed in,
+// the active release or the repository if nothing was deployed yet
+func (s *Service) WorkDir() string {
+	if _, err := os.Stat(s.CurrentPath()); err == nil {
+		return s.CurrentPath()
+	}
+
+	return s.RepoPath()
+}
+
+// Build runs the build command in dir, its output is written to the service logs
+// tagged with the ID of the deployment
+func (s *Service) Build(dir string, deployment int) error {
+	slog.Info("Building service", "name", s.Config.Name)
+
+	env, err := s.Environ(dir)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("bash", "-c", s.Config.Build)
+	cmd.Dir = dir
+	cmd.Env = env
+
+	stdout := s.logWriter(logs.StreamStdout, logs.PhaseBuild, deployment)
+	stderr := s.logWriter(logs.StreamStderr, logs.PhaseBuild, deployment)
+	defer stdout.Flush()
+	defer stderr.Flush()
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("build failed, see the build logs of deployment %d, err: %v", deployment, err)
+	}
+
+	return nil
+}
+
+// Deploy builds the checked out commit in a fresh release directory while the
+// current release keeps running. Only if the build succeeds, the service is
+// switched to the new release and restarted.
+func (s *Service) Deploy(trigger Trigger) error {
+	s.deployMu.Lock()
+	defer s.deployMu.Unlock()
+
+	return s.deploy(trigger)
+}
+
+func (s *Service) deploy(trigger Trigger) error {
+	commit, err := git.HeadCommit(s.RepoPath())
+	if err != nil {
+		return err
+	}
+
+	// the ID is reserved before building, so the build logs of a failed
+	// deployment are never tagged with the ID of the next one
+	deployment := s.History.Add(Deployment{
+		Commit:  commit,
+		Time:    time.Now(),
+		Trigger: trigger,
+		State:   DeploymentStateBuilding,
+	})
+	err = s.History.Save(s.historyPath())
+	if err != nil {
+		return err
+	}
+
+	err = s.release(deployment)
+	if err != nil {
+		s.failDeployment(deployment.ID)
+		return err
+	}
+
+	return nil
+}
+
+// release builds the deployment and replaces the running release with it
+func (s *Service) release(deployment Deployment) error {
+	commit := deployment.Commit
+	release := s.ReleasePath(commit)
+	staging := release + stagingSuffix
+
+	s.setStatus(ServiceStatusBuilding, fmt.Sprintf("building %s", commit))
+
+	err := os.RemoveAll(staging)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(staging), 0755)
+	if err != nil {
+		return err
+	}
+
+	out, err := exec.Command("cp", "-a", s.RepoPath(), staging).CombinedOutput()
+	if err != nil {
+		s.fail("copying release failed")
+		return fmt.Errorf("failed to copy release: %s, err: %v", out, err)
+	}
+	err = os.RemoveAll(filepath.Join(staging, ".git"))
+	if err != nil {
+		return err
+	}
+
+	err = s.Build(staging, deployment.ID)
+	if err != nil {
+		os.RemoveAll(staging)
+		s.fail("build failed")
+		return err
+	}
+
+	if s.Config.Deploy == config.DeployBlueGreen {
+		if s.canSwitch(commit) {
+			err = s.replaceRelease(staging, release)
+			if err != nil {
+				return err
+			}
+			return s.switchRelease(deployment, deployment.Trigger == TriggerRollback)
+		}
+
+		slog.Info("Blue/green deployment not possible, restarting instead", "name", s.Config.Name)
+	}
+
+	if s.Config.Deploy == config.DeployRolling {
+		if s.canRoll() && !s.isCurrent(commit) {
+			err = s.replaceRelease(staging, release)
+			if err != nil {
+				return err
+			}
+			return s.rollRelease(deployment, deployment.Trigger == TriggerRollback)
+		}
+
+		slog.Info("Rolling deployment not possible, restarting instead", "name", s.Config.Name)
+	}
+
+	err = s.stop()
+	if err != nil {
+		return err
+	}
+
+	err = s.replaceRelease(staging, release)
+	if err != nil {
+		return err
+	}
+
+	s.completeDeployment(deployment.ID)
+	err = s.activate(deployment, deployment.Trigger == TriggerRollback)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Deployed service", "name", s.Config.Name, "deployment", deployment.ID, "commit", commit)
+
+	return s.start()
+}
+
+// completeDeployment marks a deployment that is still building as deployed once its release is in place
+func (s *Service) completeDeployment(id int) {
+	deployment := s.History.Get(id)
+	if deployment == nil || deployment.State != DeploymentStateBuilding {
+		return
+	}
+
+	deployment.State = DeploymentStateDeployed
+	deployment.Time = time.Now()
+	deployment.Artifacts = true
+}
+
+// failDeployment marks a deployment that is still building as failed and saves the history
+func (s *Service) failDeployment(id int) {
+	deployment := s.History.Get(id)
+	if deployment == nil || deployment.State != DeploymentStateBuilding {
+		return
+	}
+
+	deployment.State = DeploymentStateFailed
+	deployment.Time = time.Now()
+
+	err := s.History.Save(s.historyPath())
+	if err != nil {
+		slog.Error("Failed to save deployment history", "name", s.Config.Name, "error", err)
+	}
+}
+
+// replaceRelease moves the staging directory to the release path,
+// a rebuild of the same commit replaces the previous release
+func (s *Service) replaceRelease(staging string, release string) error {
+	err := os.RemoveAll(release)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(staging, release)
+}
+
+// isCurrent reports whether commit is the active release, it can't be replaced while it runs
+func (s *Service) isCurrent(commit string) bool {
+	current := s.History.Get(s.History.Current)
+
+	return current != nil && current.Commit == commit
+}
+
+// canSwitch reports whether the release of commit can be started next to the running one
+func (s *Service) canSwitch(commit string) bool {
+	return s.running() &&
+		s.Config.Proxy.Enabled() &&
+		s.Config.Proxy.AlternateUpstream != "" &&
+		s.Config.HealthCheck.Type != "" &&
+		!s.isCurrent(commit)
+}
+
+// switchRelease starts the release of the deployment on the alternate upstream next to the
+// running one. Once it passes the health check, the proxy is switched to it and the old process
+// is drained and stopped. If the check fails, the old release keeps serving.
+func (s *Service) switchRelease(deployment Deployment, pinned bool) error {
+	release := s.ReleasePath(deployment.Commit)
+
+	slot := 1 - s.Slot
+	upstreams := s.slotUpstreams(slot)
+
+	slog.Info("Starting new release", "name", s.Config.Name, "upstreams", upstreams)
+	s.setStatus(ServiceStatusStarting, fmt.Sprintf("starting new release on %s", strings.Join(upstreams, ", ")))
+
+	replicas := make([]*Replica, 0, len(upstreams))
+	for i, upstream := range upstreams {
+		replica, err := s.spawn(release, i, upstream, deployment.ID)
+		if err != nil {
+			s.terminate(replicas...)
+			s.fail("starting new release failed")
+			return err
+		}
+		replicas = append(replicas, replica)
+	}
+
+	for _, replica := range replicas {
+		err := s.waitReady(replica, release)
+		if err != nil {
+			s.terminate(replicas...)
+			s.fail("readiness check failed")
+			return fmt.Errorf("readiness check failed, keeping old release: %v", err)
+		}
+	}
+
+	err := s.updateProxy(upstreams)
+	if err != nil {
+		s.terminate(replicas...)
+		s.fail("switching proxy failed")
+		return err
+	}
+
+	old := s.Replicas
+
+	s.stopHealthCheck(old...)
+	s.mu.Lock()
+	s.Replicas = replicas
+	s.Slot = slot
+	s.mu.Unlock()
+	for _, replica := range replicas {
+		s.startHealthCheck(replica)
+	}
+
+	// restarts during draining should already use the new release
+	err = s.link(deployment.Commit)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Switched proxy, draining old release", "name", s.Config.Name, "upstreams", upstreams)
+
+	time.Sleep(s.Config.DrainTime.Or(DefaultDrainTime))
+	s.terminate(old...)
+
+	s.completeDeployment(deployment.ID)
+	err = s.activate(deployment, pinned)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Deployed service", "name", s.Config.Name, "deployment", deployment.ID, "commit", deployment.Commit)
+	s.setStatus(ServiceStatusRunning, fmt.Sprintf("switched to deployment %d", deployment.ID))
+
+	return nil
+}
+
+// rollRelease restarts the replicas one at a time in the release of the deployment. If a
+// replica doesn't become ready, the replicas already rolled out return to the old release.
+func (s *Service) rollRelease(deployment Deployment, pinned bool) error {
+	release := s.ReleasePath(deployment.Commit)
+
+	err := s.rollingRestart(release, deployment.ID)
+	if err != nil {
+		slog.Error("Rolling deployment failed, restoring old release", "name", s.Config.Name, "error", err)
+
+		restoreErr := s.rollingRestart(s.WorkDir(), s.History.Current)
+		if restoreErr != nil {
+			slog.Error("Failed to restore old release", "name", s.Config.Name, "error", restoreErr)
+		}
+		s.fail("rolling deployment failed")
+
+		return err
+	}
+
+	s.completeDeployment(deployment.ID)
+	err = s.activate(deployment, pinned)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Deployed service", "name", s.Config.Name, "deployment", deployment.ID, "commit", deployment.Commit)
+	s.setStatus(ServiceStatusRunning, fmt.Sprintf("rolled out deployment %d", deployment.ID))
+
+	return nil
+}
+
+// Rollback restores a previous deployment, rebuilding it only if its artifacts are gone.
+// Like a deployment, it is switched or rolled out if the deploy strategy allows it.
+func (s *Service) Rollback(id int) error {
+	s.deployMu.Lock()
+	defer s.deployMu.Unlock()
+
+	deployment := s.History.Get(id)
+	if deployment == nil || deployment.State != DeploymentStateDeployed {
+		return errors.New("deployment not found")
+	}
+
+	slog.Info("Rolling back service", "name", s.Config.Name, "deployment", id)
+
+	if !deployment.Artifacts {
+		err := git.Checkout(s.RepoPath(), deployment.Commit)
+		if err != nil {
+			return err
+		}
+
+		return s.deploy(TriggerRollback)
+	}
+
+	if s.Config.Deploy == config.DeployBlueGreen {
+		if s.canSwitch(deployment.Commit) {
+			return s.switchRelease(*deployment, true)
+		}
+
+		slog.Info("Blue/green rollback not possible, restarting instead", "name", s.Config.Name)
+	}
+
+	if s.Config.Deploy == config.DeployRolling {
+		if s.canRoll() && !s.isCurrent(deployment.Commit) {
+			return s.rollRelease(*deployment, true)
+		}
+
+		slog.Info("Rolling rollback not possible, restarting instead", "name", s.Config.Name)
+	}
+
+	err := s.stop()
+	if err != nil {
+		return err
+	}
+
+	err = s.activate(*deployment, true)
+	if err != nil {
+		return err
+	}
+
+	return s.start()
+}
+
+// activate points the current symlink to the release of the deployment,
+// records it as active and prunes old releases. A pinned deployment isn't
+// replaced by the newest commit when the service is initialized again.
+func (s *Service) activate(deployment Deployment, pinned bool) error {
+	err := s.link(deployment.Commit)
+	if err != nil {
+		return err
+	}
+
+	s.History.Current = deployment.ID
+	s.History.Pinned = pinned
+	s.mu.Lock()
+	s.Deployment = deployment.ID
+	s.mu.Unlock()
+
+	err = s.prune()
+	if err != nil {
+		slog.Error("Failed to prune releases", "name", s.Config.Name, "error", err)
+	}
+
+	return s.History.Save(s.historyPath())
+}
+
+// link atomically replaces the current symlink
+func (s *Service) link(commit string) error {
+	tmp := s.CurrentPath() + ".tmp"
+
+	err := os.Remove(tmp)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	err = os.Symlink(filepath.Join("releases", commit), tmp)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.CurrentPath())
+}
+
+// linkLegacyRelease creates the current symlink for deployments made before it existed
+func (s *Service) linkLegacyRelease() error {
+	if _, err := os.Lstat(s.CurrentPath()); err == nil {
+		return nil
+	}
+
+	deployment := s.History.Get(s.History.Current)
+	if deployment == nil || !deployment.Artifacts {
+		return nil
+	}
+
+	return s.link(deployment.Commit)
+}
+
+// prune removes all releases except the active one and the newest ones up to KeepReleases
+func (s *Service) prune() error {
+	keep := s.Config.KeepReleases
+	if keep <= 0 {
+		keep = DefaultKeepReleases
+	}
+
+	kept := make(map[string]bool)
+	if current := s.History.Get(s.History.Current); current != nil {
+		kept[current.Commit] = true
+	}
+	for i := len(s.History.Deployments) - 1; i >= 0 && len(kept) < keep; i-- {
+		if s.History.Deployments[i].State == DeploymentStateDeployed {
+			kept[s.History.Deployments[i].Commit] = true
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(s.Path, "releases"))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		// builds in progress are removed by their deploy if they fail
+		if kept[entry.Name()] || strings.HasSuffix(entry.Name(), stagingSuffix) {
+			continue
+		}
+
+		slog.Info("Pruning release", "name", s.Config.Name, "release", entry.Name())
+		err := os.RemoveAll(filepath.Join(s.Path, "releases", entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range s.History.Deployments {
+		deployment := &s.History.Deployments[i]
+		deployment.Artifacts = deployment.State == DeploymentStateDeployed && kept[deployment.Commit]
+	}
+
+	return nil
+}
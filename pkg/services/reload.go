@@ -0,0 +1,139 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"hotify/pkg/config"
+	"log/slog"
+	"maps"
+	"path/filepath"
+	"time"
+)
+
+// Reload reads the config file again and applies it to the running services.
+// New services are initialized, removed services are stopped (their files are kept)
+// and changed services are reconfigured, so only services whose config changed restart.
+// Until the startup initialization finished, ErrInitializing is returned.
+func (m *Manager) Reload() error {
+	if !m.initialized() {
+		return ErrInitializing
+	}
+
+	var loaded config.Config
+	err := loaded.Load(m.Config.LoadPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %s, err: %v", m.Config.LoadPath, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	slog.Info("Reloading config", "path", m.Config.LoadPath)
+
+	if loaded.Address != m.Config.Address || loaded.ServicesPath != m.Config.ServicesPath || loaded.Proxy != m.Config.Proxy {
+		slog.Warn("Address, ServicesPath and Proxy changes require a restart of hotify")
+	}
+	m.configMu.Lock()
+	m.Config.Secret = loaded.Secret
+	m.Config.Logs = loaded.Logs
+	m.Config.AuditPath = loaded.AuditPath
+	m.Config.Tokens = loaded.Tokens
+	m.configMu.Unlock()
+
+	var errs []error
+
+	m.servicesMu.RLock()
+	current := maps.Clone(m.services)
+	m.servicesMu.RUnlock()
+
+	for name, service := range current {
+		if _, ok := loaded.Services[name]; ok {
+			continue
+		}
+
+		slog.Info("Service removed from config, stopping", "name", name)
+		err := service.Stop()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop service: %s, err: %v", name, err))
+		}
+		err = service.Logs.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to close logs: %s, err: %v", name, err))
+		}
+
+		m.removeService(name)
+		m.configMu.Lock()
+		delete(m.Config.Services, name)
+		m.configMu.Unlock()
+	}
+
+	// validated on load, so there is no cycle
+	order, _ := loaded.StartOrder()
+	for _, name := range order {
+		serviceConfig := loaded.Services[name]
+		service := m.Service(name)
+		if service != nil {
+			err := service.Reconfigure(serviceConfig)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to reconfigure service: %s, err: %v", name, err))
+			}
+			continue
+		}
+
+		slog.Info("Service added to config, initializing", "name", name)
+		m.configMu.Lock()
+		m.Config.Services[name] = serviceConfig
+		m.configMu.Unlock()
+		service = NewService(
+			serviceConfig,
+			filepath.Join(m.Config.ServicesPath, serviceConfig.Name),
+			m.Proxy,
+			m.logOptions(),
+		)
+		m.setService(name, service)
+
+		err := m.waitForDependencies(service)
+		if err == nil {
+			err = m.InitService(service, TriggerStartup)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to initialize service: %s, err: %v", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ConfigPollInterval is how often the config file is checked for changes
+const ConfigPollInterval = 2 * time.Second
+
+// WatchConfig reloads the config whenever the config file changes until stop is closed
+func (m *Manager) WatchConfig(stop <-chan struct{}) {
+	config.Watch(m.Config.LoadPath, ConfigPollInterval, stop, func() {
+		// changes made through the API are applied already
+		if !m.Config.ChangedOnDisk(m.Config.LoadPath) {
+			return
+		}
+
+		slog.Info("Config file changed", "path", m.Config.LoadPath)
+
+		err := m.Reload()
+		if err != nil {
+			slog.Error("Failed to reload config", "error", err)
+		}
+	})
+}
+
+// RestoreBackup replaces the config file with a backup and reloads it
+func (m *Manager) RestoreBackup(name string) error {
+	if !m.initialized() {
+		return ErrInitializing
+	}
+
+	err := config.RestoreBackup(m.Config.LoadPath, name, m.Config.Backups)
+	if err != nil {
+		return err
+	}
+
+	return m.Reload()
+}
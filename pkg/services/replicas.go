@@ -0,0 +1,209 @@
+package services
+
+import (
+	"fmt"
+	"hotify/pkg/config"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+)
+
+// Replica is a copy of the exec command of a service
+type Replica struct {
+	// Index of the replica, passed in the INSTANCE environment variable
+	Index int `json:"index"`
+	// Address the replica listens on, its port is passed in the PORT environment variable
+	Upstream string `json:"upstream"`
+	// Process ID, 0 if the replica is not running
+	PID     int          `json:"pid"`
+	Health  HealthStatus `json:"health"`
+	Started time.Time    `json:"started"`
+
+	process    *os.Process
+	exited     <-chan struct{}
+	healthStop chan struct{}
+}
+
+func (r *Replica) running() bool {
+	return r != nil && r.process != nil
+}
+
+// replicated reports whether the service runs more than one process
+func (s *Service) replicated() bool {
+	return s.Config.ReplicaCount() > 1
+}
+
+// running reports whether at least one replica is running
+func (s *Service) running() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.ContainsFunc(s.Replicas, (*Replica).running)
+}
+
+// active reports whether replica is the current replica at its index
+func (s *Service) active(replica *Replica) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return replica.Index < len(s.Replicas) && s.Replicas[replica.Index] == replica
+}
+
+// setReplica makes replica the current replica at its index
+func (s *Service) setReplica(replica *Replica) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Replicas[replica.Index] = replica
+}
+
+// setReplicas replaces all replicas
+func (s *Service) setReplicas(replicas []*Replica) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Replicas = replicas
+}
+
+// slotUpstreams returns the upstream of every replica in a blue/green slot
+func (s *Service) slotUpstreams(slot int) []string {
+	return config.ReplicaUpstreams(s.slotUpstream(slot), s.Config.ReplicaCount())
+}
+
+// Upstreams returns the upstream of every replica in the active slot
+func (s *Service) Upstreams() []string {
+	s.mu.Lock()
+	slot := s.Slot
+	s.mu.Unlock()
+
+	return s.slotUpstreams(slot)
+}
+
+// replicaUpstreams returns the upstreams of the replicas that exist right now
+func (s *Service) replicaUpstreams() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var upstreams []string
+	for _, replica := range s.Replicas {
+		upstreams = append(upstreams, replica.Upstream)
+	}
+
+	return upstreams
+}
+
+// startReplica spawns the stopped replica at index in dir and makes the new process the active one
+func (s *Service) startReplica(index int, dir string, deployment int) error {
+	replica, err := s.spawn(dir, index, s.Replicas[index].Upstream, deployment)
+	if err != nil {
+		return err
+	}
+
+	s.setReplica(replica)
+	s.startHealthCheck(replica)
+
+	return nil
+}
+
+// retire replaces the replica with a stopped one, so its exit isn't handled as a crash
+func (s *Service) retire(replica *Replica) *Replica {
+	s.stopHealthCheck(replica)
+
+	stopped := &Replica{
+		Index:    replica.Index,
+		Upstream: replica.Upstream,
+		Health:   HealthNone,
+	}
+	s.setReplica(stopped)
+
+	return stopped
+}
+
+// restartReplica restarts a crashed replica after delay, unless the service
+// was stopped, restarted or deployed in the meantime
+func (s *Service) restartReplica(stopped *Replica, delay time.Duration) {
+	time.Sleep(delay)
+
+	s.deployMu.Lock()
+	defer s.deployMu.Unlock()
+
+	if s.stopping() || !s.active(stopped) {
+		return
+	}
+
+	slog.Info("Restarting replica", "name", s.Config.Name, "replica", stopped.Index, "restarts", s.Restarts)
+
+	err := s.startReplica(stopped.Index, s.WorkDir(), s.History.Current)
+	if err != nil {
+		slog.Error("Failed to restart replica", "name", s.Config.Name, "replica", stopped.Index, "error", err)
+		return
+	}
+
+	s.setReason(fmt.Sprintf("replica %d restarted", stopped.Index))
+}
+
+// canRoll reports whether the running replicas can be replaced one at a time
+func (s *Service) canRoll() bool {
+	return s.Config.Deploy == config.DeployRolling &&
+		s.running() &&
+		len(s.Replicas) == s.Config.ReplicaCount()
+}
+
+// setRolling marks whether the replicas are restarted one at a time, the status
+// isn't changed by health checks in the meantime
+func (s *Service) setRolling(rolling bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rolling = rolling
+}
+
+// rollingRestart replaces the replicas one at a time with processes running in dir. While a
+// replica restarts, it is taken out of the proxy routes and the others keep serving. If a new
+// replica doesn't become ready, the previous one is started again and the restart stops.
+func (s *Service) rollingRestart(dir string, deployment int) error {
+	s.setRolling(true)
+	defer s.setRolling(false)
+
+	for _, old := range slices.Clone(s.Replicas) {
+		slog.Info("Restarting replica", "name", s.Config.Name, "replica", old.Index)
+		s.setStatus(ServiceStatusStarting, fmt.Sprintf("restarting replica %d of %d", old.Index+1, len(s.Replicas)))
+
+		// a single replica can't be taken out, requests fail while it restarts
+		others := slices.DeleteFunc(s.replicaUpstreams(), func(upstream string) bool {
+			return upstream == old.Upstream
+		})
+		if len(others) > 0 && old.running() {
+			s.updateProxy(others)
+			time.Sleep(s.Config.DrainTime.Or(DefaultDrainTime))
+		}
+
+		s.retire(old)
+		s.terminate(old)
+
+		// the upstream changes if the proxy config was edited
+		replica, err := s.spawn(dir, old.Index, s.Upstreams()[old.Index], deployment)
+		if err == nil && s.Config.HealthCheck.Type != "" {
+			err = s.waitReady(replica, dir)
+			if err != nil {
+				s.terminate(replica)
+			}
+		}
+		if err != nil {
+			restoreErr := s.startReplica(old.Index, s.WorkDir(), s.History.Current)
+			if restoreErr != nil {
+				slog.Error("Failed to restore replica", "name", s.Config.Name, "replica", old.Index, "error", restoreErr)
+			}
+			s.updateProxy(s.replicaUpstreams())
+
+			return fmt.Errorf("replica %d failed to start: %v", old.Index, err)
+		}
+
+		s.setReplica(replica)
+		s.startHealthCheck(replica)
+		s.updateProxy(s.replicaUpstreams())
+	}
+
+	return nil
+}
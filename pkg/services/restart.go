@@ -0,0 +1,36 @@
+package services
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	DefaultRestartDelay      = time.Second
+	DefaultMaxRestartDelay   = time.Minute
+	DefaultRestartMultiplier = 2
+	DefaultStableUptime      = 10 * time.Minute
+)
+
+// restartDelay returns the delay before the nth consecutive restart, starting at 1.
+// The delay grows exponentially from RestartDelay up to MaxRestartDelay.
+func (s *Service) restartDelay(n int) time.Duration {
+	initial := s.Config.RestartDelay.Or(DefaultRestartDelay)
+	max := s.Config.MaxRestartDelay.Or(DefaultMaxRestartDelay)
+	multiplier := s.Config.RestartMultiplier
+	if multiplier < 1 {
+		multiplier = DefaultRestartMultiplier
+	}
+
+	delay := float64(initial) * math.Pow(multiplier, float64(n-1))
+	if delay > float64(max) {
+		return max
+	}
+
+	return time.Duration(delay)
+}
+
+// stable reports whether the process ran long enough to reset the restart counter
+func (s *Service) stable(uptime time.Duration) bool {
+	return uptime >= s.Config.StableUptime.Or(DefaultStableUptime)
+}
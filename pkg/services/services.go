@@ -1,259 +1,631 @@
 package services
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"hotify/pkg/caddy"
 	"hotify/pkg/config"
 	"hotify/pkg/git"
+	"hotify/pkg/logs"
+	"hotify/pkg/proxy"
 	"log/slog"
+	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sync"
 	"syscall"
 	"time"
 )
 
-type ServiceStatus int
-
-const (
-	ServiceStatusRunning ServiceStatus = iota
-	ServiceStatusStopped
-)
-
 type Service struct {
-	Config   *config.ServiceConfig `json:"config"`
-	Caddy    *caddy.Client         `json:"-"`
-	Path     string                `json:"path"`
-	Process  *os.Process           `json:"-"`
-	Status   ServiceStatus         `json:"status"`
-	Restarts int                   `json:"restarts"`
-	Logs     []string              `json:"logs"`
+	Config *config.ServiceConfig `json:"config"`
+	Proxy  proxy.Proxy           `json:"-"`
+	Path   string                `json:"path"`
+	// Processes of the service, one per replica while it runs
+	Replicas []*Replica    `json:"replicas"`
+	Status   ServiceStatus `json:"status"`
+	// Why the service entered its status
+	Reason string `json:"reason"`
+	// Time of the last status change
+	Since time.Time `json:"since"`
+	// Exit code and time of the last process exit, nil if it never exited
+	LastExitCode *int         `json:"lastExitCode"`
+	LastExitTime *time.Time   `json:"lastExitTime"`
+	Restarts     int          `json:"restarts"`
+	Logs         *logs.Store  `json:"-"`
+	Health       HealthStatus `json:"health"`
+	// Proxy upstream in use, 0 for Upstream and 1 for AlternateUpstream
+	Slot int `json:"slot"`
+	// ID of the active deployment
+	Deployment int `json:"deployment"`
+	// Why the proxy route couldn't be synced, empty if it is up to date
+	ProxyError string             `json:"proxyError"`
+	History    *DeploymentHistory `json:"-"`
+
+	// set while the replicas are restarted one at a time
+	rolling bool
+	// serializes everything that changes the release or the processes: deploys, rollbacks,
+	// updates, starts, stops and crash restarts, which come from webhooks, the API and reloads
+	deployMu sync.Mutex
+	// guards the state read by the API and the health checks: status, health, proxy error,
+	// exit and restart info, slot, deployment and the replicas. Taken after deployMu.
+	mu sync.Mutex
+}
+
+// MarshalJSON hides secret environment values of the service config
+func (s *Service) MarshalJSON() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type alias Service
+	return json.Marshal(&struct {
+		*alias
+		Config *config.ServiceConfig `json:"config"`
+	}{
+		alias:  (*alias)(s),
+		Config: s.Config.Redacted(),
+	})
 }
 
 func NewService(
 	config *config.ServiceConfig,
 	path string,
-	caddy *caddy.Client,
+	proxy proxy.Proxy,
+	logOptions logs.Options,
 ) *Service {
 	return &Service{
 		Config: config,
-		Caddy:  caddy,
+		Proxy:  proxy,
 		Path:   path,
-		Logs:   []string{},
+		Logs:   logs.NewStore(filepath.Join(path, "logs"), logOptions),
+		Status: ServiceStatusStopped,
+		Since:  time.Now(),
+		Health: HealthNone,
+		History: &DeploymentHistory{
+			Deployments: []Deployment{},
+		},
+	}
+}
+
+// RepoPath returns the location of the git repository
+func (s *Service) RepoPath() string {
+	return filepath.Join(s.Path, "repo")
+}
+
+func (s *Service) historyPath() string {
+	return filepath.Join(s.Path, "deployments.json")
+}
+
+// Ref returns the git revision the service is pinned to
+func (s *Service) Ref() git.Ref {
+	return git.Ref{
+		Branch: s.Config.Branch,
+		Tag:    s.Config.Tag,
+		Commit: s.Config.Commit,
 	}
 }
 
 func (s *Service) Clone() error {
 	slog.Info("Cloning service", "name", s.Config.Name)
 
-	err := git.CloneRepo(s.Config.Repo, s.Path)
+	previous, reason := s.status()
+	s.setStatus(ServiceStatusCloning, "cloning repository")
+
+	err := git.CloneRepo(s.Config.Repo, s.RepoPath(), s.Ref())
 	if err != nil {
+		s.fail("clone failed")
 		return err
 	}
 
+	s.setStatus(previous, reason)
+
 	return nil
 }
 
 func (s *Service) Pull() error {
 	slog.Info("Pulling service", "name", s.Config.Name)
 
-	err := git.PullRepo(s.Path)
+	previous, reason := s.status()
+	s.setStatus(ServiceStatusPulling, "pulling repository")
+
+	err := git.PullRepo(s.RepoPath(), s.Ref())
 	if err != nil {
+		s.fail("pull failed")
 		return err
 	}
 
+	s.setStatus(previous, reason)
+
 	return nil
 }
 
 func (s *Service) AddProxy() error {
-	if s.Config.Proxy.Match == "" {
+	if !s.Config.Proxy.Enabled() {
 		return nil
 	}
 
 	slog.Info("Adding service proxy", "name", s.Config.Name)
 
-	if s.Caddy.ObjectExists(fmt.Sprintf("id/%s", caddy.GenerateID(s.Config.Proxy.Match))) {
-		return nil
+	var errs []error
+	for _, route := range s.routes(s.Upstreams()) {
+		errs = append(errs, s.Proxy.Add(route))
 	}
 
-	proxy := caddy.NewProxy(
-		caddy.GenerateID(s.Config.Proxy.Match),
-		s.Config.Proxy.Match,
-		s.Config.Proxy.Upstream,
-	)
-
-	err := s.Caddy.AddRoute(proxy)
-	return err
+	return s.proxySynced(errors.Join(errs...))
 }
 
 func (s *Service) RemoveProxy() error {
-	if s.Config.Proxy.Match == "" {
+	if !s.Config.Proxy.Enabled() {
 		return nil
 	}
 
 	slog.Info("Removing service proxy", "name", s.Config.Name)
 
-	path := fmt.Sprintf("id/%s", caddy.GenerateID(s.Config.Proxy.Match))
-	if !s.Caddy.ObjectExists(path) {
+	var errs []error
+	for _, route := range s.routes(s.Upstreams()) {
+		errs = append(errs, s.Proxy.Remove(route.ID))
+	}
+
+	return s.proxySynced(errors.Join(errs...))
+}
+
+// updateProxy points the existing routes of the service to upstreams
+func (s *Service) updateProxy(upstreams []string) error {
+	if !s.Config.Proxy.Enabled() {
 		return nil
 	}
 
-	err := s.Caddy.DeleteObject(path)
+	var errs []error
+	for _, route := range s.routes(upstreams) {
+		errs = append(errs, s.Proxy.Update(route))
+	}
+
+	return s.proxySynced(errors.Join(errs...))
+}
+
+// proxySynced records the result of syncing the proxy routes and returns err
+func (s *Service) proxySynced(err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		slog.Error("Failed to sync proxy route", "name", s.Config.Name, "error", err)
+		s.ProxyError = err.Error()
+	} else {
+		s.ProxyError = ""
+	}
+
 	return err
 }
 
-func (s *Service) Init() error {
-	slog.Info("Initializing service", "name", s.Config.Name)
+// routes returns a proxy route balancing over upstreams for every matcher of the service,
+// their IDs are derived from the service name and the index of the matcher
+func (s *Service) routes(upstreams []string) []proxy.Route {
+	maxFails, failDuration := 0, time.Duration(s.Config.Proxy.FailDuration)
+	if failDuration > 0 {
+		maxFails = max(s.Config.Proxy.MaxFails, 1)
+	}
 
-	if _, err := os.Stat(s.Path); os.IsNotExist(err) {
-		err := os.MkdirAll(s.Path, 0755)
-		if err != nil {
-			return err
-		}
+	var routes []proxy.Route
+	for i, match := range s.Config.Proxy.Matches() {
+		routes = append(routes, proxy.Route{
+			ID:            proxy.RouteID(s.Config.Name, i),
+			Hosts:         match.Hosts,
+			Paths:         proxy.NormalizePaths(match.Paths),
+			StripPrefix:   match.StripPrefix,
+			Upstreams:     upstreams,
+			LoadBalancing: s.Config.Proxy.LoadBalancing,
+			MaxFails:      maxFails,
+			FailDuration:  failDuration,
+		})
+	}
 
-		err = s.Clone()
+	return routes
+}
+
+// migrate moves a repository cloned directly into the service path into RepoPath
+func (s *Service) migrate() error {
+	if _, err := os.Stat(filepath.Join(s.Path, ".git")); err != nil {
+		return nil
+	}
+
+	slog.Info("Migrating service layout", "name", s.Config.Name)
+
+	tmp := s.Path + ".migrate"
+	err := os.Rename(s.Path, tmp)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(s.Path, 0755)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return os.Rename(tmp, s.RepoPath())
 }
 
-func (s *Service) Update() error {
-	slog.Info("Updating service", "name", s.Config.Name)
+func (s *Service) Init() error {
+	slog.Info("Initializing service", "name", s.Config.Name)
+
+	err := s.migrate()
+	if err != nil {
+		return err
+	}
 
-	err := s.Stop()
+	err = os.MkdirAll(s.Path, 0755)
 	if err != nil {
 		return err
 	}
-	err = s.Pull()
+
+	history, err := LoadDeploymentHistory(s.historyPath())
 	if err != nil {
 		return err
 	}
-	err = s.Build()
+	s.History = history
+	s.Deployment = history.Current
+
+	err = s.linkLegacyRelease()
 	if err != nil {
 		return err
 	}
-	err = s.Start()
-	return err
+
+	if _, err := os.Stat(s.RepoPath()); os.IsNotExist(err) {
+		err = s.Clone()
+		return err
+	}
+
+	return nil
 }
 
-func (s *Service) Build() error {
-	slog.Info("Building service", "name", s.Config.Name)
+// Update pulls the repository and deploys it, the service keeps running until the build succeeded
+func (s *Service) Update(trigger Trigger) error {
+	s.deployMu.Lock()
+	defer s.deployMu.Unlock()
 
-	cmd := exec.Command("bash", "-c", s.Config.Build)
-	cmd.Dir = s.Path
+	return s.update(trigger)
+}
 
-	var writer LogWriter
-	writer.Service = s
-	cmd.Stdout = &writer
-	cmd.Stderr = &writer
+func (s *Service) update(trigger Trigger) error {
+	slog.Info("Updating service", "name", s.Config.Name)
 
-	err := cmd.Run()
+	err := s.Pull()
 	if err != nil {
-		return fmt.Errorf("build failed: %s, err: %v", writer.Service.Logs, err)
+		return err
 	}
 
-	return nil
+	err = s.deploy(trigger)
+	return err
 }
 
 func (s *Service) Stop() error {
+	s.deployMu.Lock()
+	defer s.deployMu.Unlock()
+
+	return s.stop()
+}
+
+func (s *Service) stop() error {
 	slog.Info("Stopping service", "name", s.Config.Name)
 
-	s.Status = ServiceStatusStopped
+	s.setStatus(ServiceStatusStopping, "stop requested")
 
-	err := s.RemoveProxy()
+	err := s.halt()
 	if err != nil {
 		return err
 	}
 
-	// if process is running
-	if s.Process != nil {
-		waiting := make(chan bool)
+	s.setStatus(ServiceStatusStopped, "stopped")
 
-		go func() {
-			s.Process.Signal(syscall.SIGTERM)
-			s.Process.Wait()
-			close(waiting)
-		}()
+	return nil
+}
 
-		select {
-		case <-waiting:
-		case <-time.After(5 * time.Second):
-			slog.Info("Process did not exit after 5 seconds, killing", "name", s.Config.Name)
-			s.Process.Kill()
+// halt stops the health checks, removes the proxy and terminates the replicas that are running
+func (s *Service) halt() error {
+	s.stopHealthCheck(s.Replicas...)
+
+	// the process is stopped anyway, the route is removed by the next reconciliation
+	s.RemoveProxy()
+
+	replicas := s.Replicas
+	s.setReplicas(nil)
+	s.terminate(replicas...)
+
+	return nil
+}
+
+// terminate sends SIGTERM to the running replicas and kills those that didn't exit after 5 seconds
+func (s *Service) terminate(replicas ...*Replica) {
+	for _, replica := range replicas {
+		if replica.running() {
+			replica.process.Signal(syscall.SIGTERM)
 		}
 	}
 
-	s.Process = nil
+	deadline := time.Now().Add(5 * time.Second)
+	for _, replica := range replicas {
+		if !replica.running() {
+			continue
+		}
 
-	return nil
+		select {
+		case <-replica.exited:
+		case <-time.After(time.Until(deadline)):
+			slog.Info("Process did not exit after 5 seconds, killing", "name", s.Config.Name, "replica", replica.Index)
+			replica.process.Kill()
+			<-replica.exited
+		}
+	}
 }
 
+// LogWriter writes command output to the service logs. Errors are logged instead
+// of returned, so a failing log file never blocks the command.
 type LogWriter struct {
 	Service *Service
+	Writer  *logs.Writer
+}
+
+func (s *Service) logWriter(stream logs.Stream, phase logs.Phase, deployment int) *LogWriter {
+	return &LogWriter{
+		Service: s,
+		Writer:  s.Logs.Writer(stream, phase, deployment),
+	}
 }
 
 func (w *LogWriter) Write(p []byte) (n int, err error) {
-	s := string(p)
-	w.Service.Logs = append(w.Service.Logs, s)
+	_, err = w.Writer.Write(p)
+	if err != nil {
+		slog.Error("Failed to write logs", "name", w.Service.Config.Name, "error", err)
+	}
+
 	return len(p), nil
 }
 
-func (s *Service) Start() error {
-	slog.Info("Starting service", "name", s.Config.Name)
+// Flush writes an incomplete last line, call it after the command exited
+func (w *LogWriter) Flush() {
+	err := w.Writer.Flush()
+	if err != nil {
+		slog.Error("Failed to write logs", "name", w.Service.Config.Name, "error", err)
+	}
+}
+
+// slotUpstream returns the upstream of the first replica in a blue/green slot
+func (s *Service) slotUpstream(slot int) string {
+	if slot == 1 && s.Config.Proxy.AlternateUpstream != "" {
+		return s.Config.Proxy.AlternateUpstream
+	}
 
-	s.Status = ServiceStatusRunning
+	return s.Config.Proxy.Upstream
+}
 
-	err := s.AddProxy()
+// processEnv returns the environment of the replica at index running in dir. Blue/green
+// deployments and replicas get the port of their upstream in the PORT environment variable,
+// replicas get their index in INSTANCE.
+func (s *Service) processEnv(dir string, index int, upstream string) ([]string, error) {
+	env, err := s.Environ(dir)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	cmd := exec.Command("bash", "-c", s.Config.Exec)
-	cmd.Dir = s.Path
+	if s.Config.Deploy == config.DeployBlueGreen || s.replicated() {
+		if _, port, err := net.SplitHostPort(upstream); err == nil {
+			env = append(env, "PORT="+port)
+		}
+	}
+	if s.replicated() {
+		env = append(env, fmt.Sprintf("INSTANCE=%d", index))
+	}
+
+	return env, nil
+}
 
-	var writer LogWriter
-	writer.Service = s
-	cmd.Stdout = &writer
-	cmd.Stderr = &writer
+// spawn starts the exec command of the replica at index in dir, the replica is only
+// active once it is stored in Replicas. Output is logged as part of the given deployment,
+// prefixed with the index if the service has replicas.
+func (s *Service) spawn(dir string, index int, upstream string, deployment int) (*Replica, error) {
+	env, err := s.processEnv(dir, index, upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command("bash", "-c", s.Config.Exec)
+	cmd.Dir = dir
+	cmd.Env = env
+
+	stdout := s.logWriter(logs.StreamStdout, logs.PhaseRun, deployment)
+	stderr := s.logWriter(logs.StreamStderr, logs.PhaseRun, deployment)
+	if s.replicated() {
+		stdout.Writer.Prefix = fmt.Sprintf("[%d] ", index)
+		stderr.Writer.Prefix = stdout.Writer.Prefix
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// don't wait forever for output of child processes that outlive the process
+	cmd.WaitDelay = 5 * time.Second
 
 	err = cmd.Start()
 	if err != nil {
-		return fmt.Errorf("start failed: %s, err: %v", writer.Service.Logs, err)
+		return nil, fmt.Errorf("start failed: %v", err)
 	}
 
-	s.Process = cmd.Process
-	go func() {
-		state, _ := s.Process.Wait()
+	exited := make(chan struct{})
+	replica := &Replica{
+		Index:    index,
+		Upstream: upstream,
+		PID:      cmd.Process.Pid,
+		Health:   HealthNone,
+		Started:  time.Now(),
+		process:  cmd.Process,
+		exited:   exited,
+	}
+	go s.watch(replica, cmd, exited, stdout, stderr)
 
-		if s.Status == ServiceStatusStopped {
-			return
-		}
+	return replica, nil
+}
+
+// watch waits for the process of the replica to exit and restarts it if it is still active.
+// Once no replica is left, the whole service is restarted.
+func (s *Service) watch(replica *Replica, cmd *exec.Cmd, exited chan<- struct{}, writers ...*LogWriter) {
+	cmd.Wait()
+	for _, writer := range writers {
+		writer.Flush()
+	}
+	close(exited)
+
+	state := cmd.ProcessState
 
-		slog.Info("Service exited", "name", s.Config.Name, "code", state.ExitCode())
+	// operations stopping the replica wait for exited, so it is closed before locking
+	s.deployMu.Lock()
+	if s.stopping() || !s.active(replica) {
+		s.deployMu.Unlock()
+		return
+	}
+
+	code := state.ExitCode()
+	now := time.Now()
+	s.mu.Lock()
+	s.LastExitCode = &code
+	s.LastExitTime = &now
+	s.mu.Unlock()
+
+	slog.Info("Service exited", "name", s.Config.Name, "replica", replica.Index, "code", code)
+
+	stopped := s.retire(replica)
+	// the other replicas keep serving
+	degraded := s.running()
+	if !degraded {
+		// free resources, the process already exited
+		s.halt()
+	}
 
-		if !s.Config.Restart {
-			s.Stop() // mark as stopped, free resources
-			return
+	status := ServiceStatusCrashed
+	if code == 0 {
+		status = ServiceStatusStopped
+	}
+	reason := fmt.Sprintf("exited with code %d", code)
+	if !state.Exited() {
+		reason = fmt.Sprintf("terminated by %s", state.String())
+	}
+	if s.replicated() {
+		reason = fmt.Sprintf("replica %d %s", replica.Index, reason)
+	}
+
+	if !s.Config.Restart {
+		if degraded {
+			s.setReason(reason)
+		} else {
+			s.setStatus(status, reason)
 		}
+		s.deployMu.Unlock()
+		return
+	}
 
-		if s.Restarts >= s.Config.MaxRestarts {
-			slog.Error("Service reached max restarts", "name", s.Config.Name)
-			s.Stop()
-			return
+	s.mu.Lock()
+	if s.stable(now.Sub(replica.Started)) {
+		s.Restarts = 0
+	}
+	restarts := s.Restarts + 1
+	if restarts <= s.Config.MaxRestarts {
+		s.Restarts = restarts
+	}
+	s.mu.Unlock()
+
+	if restarts > s.Config.MaxRestarts {
+		slog.Error("Service reached max restarts", "name", s.Config.Name)
+		s.halt()
+		s.setStatus(ServiceStatusFailed, fmt.Sprintf("%s, reached max restarts", reason))
+		s.deployMu.Unlock()
+		return
+	}
+
+	delay := s.restartDelay(restarts)
+	reason = fmt.Sprintf("%s, restarting in %s (%d/%d)", reason, delay, restarts, s.Config.MaxRestarts)
+
+	if degraded {
+		s.setReason(reason)
+		s.deployMu.Unlock()
+		s.restartReplica(stopped, delay)
+		return
+	}
+
+	s.setStatus(ServiceStatusBackingOff, reason)
+	s.deployMu.Unlock()
+
+	time.Sleep(delay)
+
+	s.deployMu.Lock()
+	defer s.deployMu.Unlock()
+
+	// the service was stopped or started manually while backing off
+	if status, _ := s.status(); status != ServiceStatusBackingOff || s.running() {
+		return
+	}
+
+	slog.Info("Restarting service", "name", s.Config.Name, "restarts", restarts)
+	s.start()
+}
+
+// Start starts the replicas of the service, it does nothing if the service is already running
+func (s *Service) Start() error {
+	s.deployMu.Lock()
+	defer s.deployMu.Unlock()
+
+	if s.running() {
+		slog.Info("Service is already running", "name", s.Config.Name)
+		return nil
+	}
+
+	return s.start()
+}
+
+func (s *Service) start() error {
+	slog.Info("Starting service", "name", s.Config.Name)
+
+	s.setStatus(ServiceStatusStarting, "starting process")
+
+	// a failed route is shown in ProxyError and fixed by the next reconciliation
+	s.AddProxy()
+
+	var replicas []*Replica
+	for i, upstream := range s.Upstreams() {
+		replicas = append(replicas, &Replica{Index: i, Upstream: upstream, Health: HealthNone})
+	}
+	s.setReplicas(replicas)
+	for i := range replicas {
+		err := s.startReplica(i, s.WorkDir(), s.History.Current)
+		if err != nil {
+			s.halt()
+			s.fail("start failed")
+			return err
 		}
+	}
 
-		s.Restarts++
-		slog.Info("Restarting service", "name", s.Config.Name, "restarts", s.Restarts)
-		s.Start()
-	}()
+	// with a health check, the service is running once it passes
+	if s.Config.HealthCheck.Type == "" {
+		reason := "process started"
+		if s.replicated() {
+			reason = fmt.Sprintf("%d replicas started", len(s.Replicas))
+		}
+		s.setStatus(ServiceStatusRunning, reason)
+	} else {
+		s.setStatus(ServiceStatusStarting, "waiting for health check")
+	}
 
 	return nil
 }
 
 func (s *Service) Remove() error {
+	s.deployMu.Lock()
+	defer s.deployMu.Unlock()
+
 	slog.Info("Removing service", "name", s.Config.Name)
 
-	err := s.Stop()
+	err := s.stop()
+	if err != nil {
+		return err
+	}
+
+	err = s.Logs.Close()
 	if err != nil {
 		return err
 	}
@@ -262,14 +634,33 @@ func (s *Service) Remove() error {
 	return err
 }
 
+// Restart stops and starts the service, with rolling deploys the replicas are restarted one at a time
 func (s *Service) Restart() error {
+	s.deployMu.Lock()
+	defer s.deployMu.Unlock()
+
+	return s.restart()
+}
+
+func (s *Service) restart() error {
 	slog.Info("Restarting service", "name", s.Config.Name)
 
-	err := s.Stop()
+	if s.canRoll() {
+		err := s.rollingRestart(s.WorkDir(), s.History.Current)
+		if err != nil {
+			s.fail("rolling restart failed")
+			return err
+		}
+
+		s.setStatus(ServiceStatusRunning, "restarted replicas")
+		return nil
+	}
+
+	err := s.stop()
 	if err != nil {
 		return err
 	}
 
-	err = s.Start()
+	err = s.start()
 	return err
 }
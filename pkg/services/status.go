@@ -0,0 +1,74 @@
+package services
+
+import (
+	"log/slog"
+	"time"
+)
+
+type ServiceStatus string
+
+const (
+	ServiceStatusPending    ServiceStatus = "pending"
+	ServiceStatusCloning    ServiceStatus = "cloning"
+	ServiceStatusPulling    ServiceStatus = "pulling"
+	ServiceStatusBuilding   ServiceStatus = "building"
+	ServiceStatusStarting   ServiceStatus = "starting"
+	ServiceStatusRunning    ServiceStatus = "running"
+	ServiceStatusStopping   ServiceStatus = "stopping"
+	ServiceStatusStopped    ServiceStatus = "stopped"
+	ServiceStatusCrashed    ServiceStatus = "crashed"
+	ServiceStatusFailed     ServiceStatus = "failed"
+	ServiceStatusBackingOff ServiceStatus = "backing-off"
+)
+
+// setStatus transitions the service to status, reason explains why
+func (s *Service) setStatus(status ServiceStatus, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.updateStatus(status, reason)
+}
+
+// setReason keeps the status and replaces the reason
+func (s *Service) setReason(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.updateStatus(s.Status, reason)
+}
+
+// updateStatus sets status and reason, s.mu must be held
+func (s *Service) updateStatus(status ServiceStatus, reason string) {
+	if s.Status != status || s.Reason != reason {
+		slog.Info("Service status changed", "name", s.Config.Name, "from", s.Status, "to", status, "reason", reason)
+	}
+
+	s.Status = status
+	s.Reason = reason
+	s.Since = time.Now()
+}
+
+// fail records a failed operation. If a process is still serving, the service
+// stays running, otherwise it is marked as failed.
+func (s *Service) fail(reason string) {
+	if s.running() {
+		s.setStatus(ServiceStatusRunning, reason+", previous release still running")
+		return
+	}
+
+	s.setStatus(ServiceStatusFailed, reason)
+}
+
+// status returns the status and its reason
+func (s *Service) status() (ServiceStatus, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.Status, s.Reason
+}
+
+// stopping reports whether the service is being stopped on purpose
+func (s *Service) stopping() bool {
+	status, _ := s.status()
+	return status == ServiceStatusStopping || status == ServiceStatusStopped
+}
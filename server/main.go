@@ -3,8 +3,8 @@ package main
 import (
 	"flag"
 	"hotify/pkg/api"
-	"hotify/pkg/caddy"
 	"hotify/pkg/config"
+	"hotify/pkg/proxy"
 	s "hotify/pkg/services"
 	"hotify/pkg/update"
 	"hotify/webui"
@@ -48,26 +48,28 @@ func main() {
 		os.Exit(1)
 	}
 
-	caddyClient := caddy.NewClient(
-		"srv0",
-		"http://localhost:2019",
-	)
-
-	err = caddyClient.Init()
+	reverseProxy, err := proxy.New(config.Proxy)
+	if err != nil && reverseProxy == nil {
+		slog.Error("Could not initialize reverse proxy", "err", err)
+		os.Exit(1)
+	}
 	if err != nil {
-		slog.Error("Could not initialize Caddy client", "err", err)
+		// routes that fail are marked unsynced and added by the next reconciliation
+		slog.Error("Could not initialize reverse proxy, retrying on the next sync. Set Proxy.Type = 'builtin' to run without Caddy", "err", err)
 	}
 
-	manager := s.NewManager(&config, caddyClient)
+	manager := s.NewManager(&config, reverseProxy)
 	updater := update.NewSystemdUpdater(CommitHash)
 
 	e := echo.New()
 	e.HideBanner = true
 	e.HidePort = true
+	// forwarded headers are only trusted from a proxy on the same host, so clients can't fake the IP in the audit log
+	e.IPExtractor = echo.ExtractIPFromXFFHeader(echo.TrustLinkLocal(false), echo.TrustPrivateNet(false))
 
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins: []string{"*"},
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, "X-Signature-256"},
+		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, api.SignatureHeader, api.TimestampHeader, api.NonceHeader},
 	}))
 	e.Pre(SPAMiddleware)
 
@@ -78,6 +80,10 @@ func main() {
 	frontend := echo.MustSubFS(webui.Assets, "build")
 	e.StaticFS("/", frontend)
 
+	// registered before initializing, so a reload during startup doesn't kill hotify
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+
 	go func() {
 		slog.Info("Starting server", "address", config.Address)
 		err := e.Start(config.Address)
@@ -87,20 +93,55 @@ func main() {
 		}
 	}()
 
-	err = manager.Init()
-	if err != nil {
-		slog.Error("Could not initialize services", "err", err)
-		os.Exit(1)
+	initialized := make(chan struct{})
+	go func() {
+		defer close(initialized)
+
+		// failed services are marked as failed, the others keep running
+		err := manager.Init()
+		if err != nil {
+			slog.Error("Some services could not be initialized", "err", err)
+		}
+	}()
+
+	// reloads wait until the services are initialized, other signals exit right away
+	reload := false
+	for waiting := true; waiting; {
+		select {
+		case <-initialized:
+			waiting = false
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				slog.Info("Exiting during initialization")
+				os.Exit(0)
+			}
+			slog.Info("Reload queued until the services are initialized")
+			reload = true
+		}
+	}
+	if reload {
+		err := manager.Reload()
+		if err != nil {
+			slog.Error("Could not reload config", "err", err)
+		}
 	}
 
 	// start the updater AFTER all services are initialized
 	// ensures that there are no weird errors while updating services
 	go updater.Run()
 
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
+	stopWatch := make(chan struct{})
+	go manager.WatchConfig(stopWatch)
+	go manager.WatchProxy(stopWatch)
 
-	<-signals
+	// SIGHUP reloads the config, every other signal exits
+	for sig := <-signals; sig == syscall.SIGHUP; sig = <-signals {
+		err := manager.Reload()
+		if err != nil {
+			slog.Error("Could not reload config", "err", err)
+		}
+	}
+	close(stopWatch)
 
 	err = manager.Stop()
 	if err != nil {
@@ -1,11 +1,16 @@
+// how the credential authenticates requests, chosen at login
+type AuthMode = 'secret' | 'token';
+
 class Client {
 	public address: string;
 	public secret: string;
+	public mode: AuthMode;
 	public onUpdate?: () => void;
 
-	constructor(address: string, secret: string) {
+	constructor(address: string, secret: string, mode: AuthMode = 'secret') {
 		this.address = address;
 		this.secret = secret;
+		this.mode = mode;
 	}
 
 	private async createHmac(data: string): Promise<string> {
@@ -24,7 +29,12 @@ class Client {
 			.join('');
 	}
 
-	private async fetch(method: string, path: string, body?: unknown): Promise<Response> {
+	private async fetch(
+		method: string,
+		path: string,
+		body?: unknown,
+		signal?: AbortSignal
+	): Promise<Response> {
 		const url = `${this.address}/${path}`;
 		const headers: HeadersInit = {
 			'Content-Type': 'application/json'
@@ -32,13 +42,31 @@ class Client {
 
 		const stringBody = body ? JSON.stringify(body) : '';
 
-		const hmac = await this.createHmac(stringBody);
-		headers['X-Signature-256'] = `sha256=${hmac}`;
+		if (this.mode === 'token') {
+			// scoped API tokens are sent as bearer tokens
+			headers['Authorization'] = `Bearer ${this.secret}`;
+		} else {
+			// the signature covers timestamp, nonce, method, path with query and body
+			// so a captured request can't be replayed
+			const { pathname, search } = new URL(url, window.location.href);
+			const timestamp = String(Math.floor(Date.now() / 1000));
+			const nonce = Array.from(crypto.getRandomValues(new Uint8Array(16)))
+				.map((b) => b.toString(16).padStart(2, '0'))
+				.join('');
+
+			const hmac = await this.createHmac(
+				`${timestamp}\n${nonce}\n${method}\n${pathname}${search}\n${stringBody}`
+			);
+			headers['X-Timestamp'] = timestamp;
+			headers['X-Nonce'] = nonce;
+			headers['X-Signature-256'] = `sha256=${hmac}`;
+		}
 
 		const response = await fetch(url, {
 			method,
 			headers,
-			body: stringBody || undefined
+			body: stringBody || undefined,
+			signal
 		});
 
 		if (!response.ok) {
@@ -54,6 +82,11 @@ class Client {
 		return response.json();
 	}
 
+	async initProgress(): Promise<InitProgress> {
+		const response = await this.fetch('GET', 'api/init');
+		return response.json();
+	}
+
 	async services(): Promise<Service[]> {
 		const response = await this.fetch('GET', 'api/services');
 		return response.json();
@@ -84,6 +117,74 @@ class Client {
 		this.onUpdate?.();
 	}
 
+	// applies a JSON merge patch to the service config, null removes a key
+	async editService(
+		name: string,
+		patch: Partial<Omit<ServiceConfig, 'proxy'>> & { proxy?: Partial<ProxyConfig> }
+	): Promise<ServiceConfig> {
+		const response = await this.fetch('PATCH', `api/services/${name}`, patch);
+		this.onUpdate?.();
+		return response.json();
+	}
+
+	async setServiceEnv(name: string, env: ServiceEnv): Promise<void> {
+		await this.fetch('PUT', `api/services/${name}/env`, env);
+		this.onUpdate?.();
+	}
+
+	// params may contain offset, limit, stream, phase, deployment and since
+	async logs(name: string, params: Record<string, string>): Promise<LogPage> {
+		const query = new URLSearchParams(params).toString();
+		const response = await this.fetch('GET', `api/services/${name}/logs?${query}`);
+		return response.json();
+	}
+
+	// streams log entries until the server ends the stream or signal is aborted,
+	// params may contain after, tail, stream, phase, deployment and since
+	async streamLogs(
+		name: string,
+		params: Record<string, string>,
+		onEntry: (entry: LogEntry) => void,
+		signal: AbortSignal
+	): Promise<void> {
+		const query = new URLSearchParams(params).toString();
+		const response = await this.fetch(
+			'GET',
+			`api/services/${name}/logs/stream?${query}`,
+			undefined,
+			signal
+		);
+		if (!response.body) return;
+
+		const reader = response.body.pipeThrough(new TextDecoderStream()).getReader();
+		let buffer = '';
+		for (;;) {
+			const { value, done } = await reader.read();
+			if (done) return;
+
+			buffer += value;
+			const events = buffer.split('\n\n');
+			buffer = events.pop() ?? '';
+			for (const event of events) {
+				for (const line of event.split('\n')) {
+					if (line.startsWith('data: ')) {
+						onEntry(JSON.parse(line.slice('data: '.length)));
+					}
+				}
+			}
+		}
+	}
+
+	async deployments(name: string): Promise<DeploymentHistory> {
+		const response = await this.fetch('GET', `api/services/${name}/deployments`);
+		return response.json();
+	}
+
+	async rollbackService(name: string, deployment: number): Promise<void> {
+		await this.fetch('POST', `api/services/${name}/rollback/${deployment}`);
+		this.onUpdate?.();
+	}
+
 	async deleteService(name: string): Promise<void> {
 		await this.fetch('DELETE', `api/services/${name}`);
 		this.onUpdate?.();
@@ -95,9 +196,20 @@ class Client {
 	}
 }
 
-interface ProxyConfig {
+interface ProxyMatch {
+	hosts: string[] | null;
+	paths: string[] | null;
+	stripPrefix: boolean;
+}
+
+interface ProxyConfig extends ProxyMatch {
 	match: string;
 	upstream: string;
+	alternateUpstream: string;
+	routes: ProxyMatch[] | null;
+	loadBalancing: '' | 'round_robin' | 'least_conn' | 'random' | 'first' | 'ip_hash';
+	maxFails: number;
+	failDuration: string;
 }
 
 interface Config {
@@ -111,27 +223,148 @@ interface Config {
 interface Service {
 	config: ServiceConfig;
 	path: string;
+	replicas: Replica[] | null;
 	status: ServiceStatus;
+	reason: string;
+	since: string;
+	lastExitCode: number | null;
+	lastExitTime: string | null;
 	restarts: number;
-	logs: string[];
+	health: HealthStatus;
+	slot: number;
+	deployment: number;
+	proxyError: string;
+}
+
+type HealthStatus = 'none' | 'starting' | 'healthy' | 'unhealthy';
+
+interface Replica {
+	index: number;
+	upstream: string;
+	// 0 if the replica is not running
+	pid: number;
+	health: HealthStatus;
+	started: string;
+}
+
+interface HealthCheckConfig {
+	type: '' | 'http' | 'tcp' | 'exec';
+	url: string;
+	address: string;
+	command: string;
+	interval: string;
+	timeout: string;
+	retries: number;
+	startPeriod: string;
+	restart: boolean;
+}
+
+interface LogEntry {
+	seq: number;
+	time: string;
+	stream: 'stdout' | 'stderr';
+	phase: 'build' | 'run';
+	deployment: number;
+	message: string;
+}
+
+interface LogPage {
+	entries: LogEntry[];
+	more: boolean;
+}
+
+interface ServiceInit {
+	state: 'pending' | 'initializing' | 'done' | 'failed';
+	error: string;
+	started: string | null;
+	finished: string | null;
+}
+
+interface InitProgress {
+	running: boolean;
+	started: string;
+	finished: string | null;
+	services: { [name: string]: ServiceInit };
+}
+
+interface Deployment {
+	id: number;
+	commit: string;
+	time: string;
+	trigger: 'startup' | 'api' | 'webhook' | 'rollback';
+	state: 'building' | 'failed' | 'deployed';
+	artifacts: boolean;
+}
+
+interface DeploymentHistory {
+	current: number;
+	pinned: boolean;
+	deployments: Deployment[];
 }
 
 interface ServiceConfig {
 	name: string;
 	repo: string;
+	branch: string;
+	tag: string;
+	commit: string;
 	exec: string;
 	build: string;
 	restart: boolean;
 	maxRestarts: number;
+	restartDelay: string;
+	maxRestartDelay: string;
+	restartMultiplier: number;
+	stableUptime: string;
 	secret: string;
 	proxy: ProxyConfig;
+	healthCheck: HealthCheckConfig;
+	replicas: number;
+	deploy: '' | 'restart' | 'bluegreen' | 'rolling';
+	drainTime: string;
+	keepReleases: number;
+	dependsOn: string[] | null;
+	env: { [key: string]: string } | null;
+	envFile: string[] | null;
+	secretEnv: string[] | null;
+}
+
+interface ServiceEnv {
+	env: { [key: string]: string };
+	envFile: string[];
+	secretEnv: string[];
 }
 
 enum ServiceStatus {
-	Running = 0,
-	Stopped = 1
+	Pending = 'pending',
+	Cloning = 'cloning',
+	Pulling = 'pulling',
+	Building = 'building',
+	Starting = 'starting',
+	Running = 'running',
+	Stopping = 'stopping',
+	Stopped = 'stopped',
+	Crashed = 'crashed',
+	Failed = 'failed',
+	BackingOff = 'backing-off'
 }
 
-export type { ProxyConfig, Config, Service, ServiceConfig };
+export type {
+	ProxyMatch,
+	ProxyConfig,
+	Config,
+	Service,
+	Replica,
+	ServiceConfig,
+	ServiceEnv,
+	HealthStatus,
+	HealthCheckConfig,
+	LogEntry,
+	LogPage,
+	ServiceInit,
+	InitProgress,
+	Deployment,
+	DeploymentHistory
+};
 
-export { Client, ServiceStatus };
+export { Client, ServiceStatus, type AuthMode };
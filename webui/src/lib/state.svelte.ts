@@ -1,16 +1,18 @@
-import { type Service, Client } from './client';
+import { type AuthMode, type InitProgress, type Service, Client } from './client';
 
 export const config: {
 	address: string;
 	secret: string;
+	mode: AuthMode;
 } = $state({
 	address: import.meta.env.DEV ? 'http://localhost:1234' : window.location.origin,
-	secret: 'secret'
+	secret: 'secret',
+	mode: 'secret'
 });
 
 export const getClient = () => client;
 
-export const client = new Client(config.address, config.secret);
+export const client = new Client(config.address, config.secret, config.mode);
 client.onUpdate = () => {
 	loadServices();
 };
@@ -21,3 +23,14 @@ export const loadServices = async () => {
 	services.splice(0, services.length, ...res);
 	services.sort((a, b) => a.config.name.localeCompare(b.config.name));
 };
+
+export const init: { progress: InitProgress | null } = $state({ progress: null });
+// polls the services while they are initialized at startup
+export const watchInit = async () => {
+	init.progress = await client.initProgress();
+	while (init.progress.running) {
+		await new Promise((resolve) => setTimeout(resolve, 2000));
+		init.progress = await client.initProgress();
+		await loadServices();
+	}
+};